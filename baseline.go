@@ -0,0 +1,97 @@
+// Copyright (C) 2020 Fabio Del Vigna
+//
+// This file is part of drbracket.
+//
+// drbracket is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// drbracket is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with drbracket.  If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+
+	"github.com/sirupsen/logrus"
+	"github.com/yoskini/drbracket/lib/baseline"
+	"github.com/yoskini/drbracket/lib/parser"
+)
+
+// baselineFilter either records every diagnostic into a new baseline or
+// drops the ones already recorded in an existing one.
+type baselineFilter struct {
+	base    *baseline.Baseline
+	record  bool
+	checked map[string]bool
+}
+
+func newBaselineFilter(path string) (*baselineFilter, error) {
+	if path == "" {
+		return &baselineFilter{
+			base:   baseline.New(),
+			record: true,
+		}, nil
+	}
+	base, err := baseline.Load(path)
+	if err != nil {
+		return nil, err
+	}
+	return &baselineFilter{
+		base:    base,
+		checked: make(map[string]bool),
+	}, nil
+}
+
+func (f *baselineFilter) apply(src *source, diagnostics []parser.Diagnostic) ([]parser.Diagnostic, error) {
+	file := filepath.ToSlash(src.name)
+	if f.record {
+		for _, d := range diagnostics {
+			f.base.Add(baseline.NewEntry(file, src.lines, d))
+		}
+		return nil, nil
+	}
+	f.checked[file] = true
+	res := make([]parser.Diagnostic, 0, len(diagnostics))
+	for _, d := range diagnostics {
+		if !f.base.Match(baseline.NewEntry(file, src.lines, d)) {
+			res = append(res, d)
+		}
+	}
+	return res, nil
+}
+
+// rewind lets the diagnostics of a source that is checked again match the
+// entries they matched the first time.
+func (f *baselineFilter) rewind(src string) {
+	if !f.record {
+		f.base.Rewind(filepath.ToSlash(sourceName(src)))
+	}
+}
+
+// finish writes the recorded baseline, or reports the entries of the loaded
+// one that no longer occur so that they can be removed.
+func (f *baselineFilter) finish(path string) error {
+	if f.record {
+		return f.base.Save(path)
+	}
+	stale := f.base.Stale(func(file string) bool {
+		if f.checked[file] {
+			return true
+		}
+		_, err := os.Stat(filepath.FromSlash(file))
+		return os.IsNotExist(err)
+	})
+	for _, e := range stale {
+		logrus.Warnf("File %s: Baseline entry no longer occurs: %s", e.File, e.Message)
+	}
+	return nil
+}
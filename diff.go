@@ -0,0 +1,138 @@
+// Copyright (C) 2020 Fabio Del Vigna
+//
+// This file is part of drbracket.
+//
+// drbracket is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// drbracket is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with drbracket.  If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/yoskini/drbracket/lib/git"
+	"github.com/yoskini/drbracket/lib/parser"
+)
+
+// diffFilter drops the diagnostics of a changed file that were already
+// present in the old revision and sit outside the lines touched by the diff.
+type diffFilter struct {
+	repo    *git.Repo
+	base    string
+	changes map[string]git.Change
+	// known caches the problems of the old revisions, which the cells of
+	// a notebook share.
+	known map[string]map[string]int
+}
+
+func newDiffFilter(repo *git.Repo, base string, changes map[string]git.Change) *diffFilter {
+	return &diffFilter{
+		repo:    repo,
+		base:    base,
+		changes: changes,
+		known:   make(map[string]map[string]int),
+	}
+}
+
+func (f *diffFilter) apply(src *source, diagnostics []parser.Diagnostic) ([]parser.Diagnostic, error) {
+	c, ok := f.changes[src.path]
+	if !ok || c.Status == git.StatusAdded || len(diagnostics) == 0 {
+		return diagnostics, nil
+	}
+	var hunks []git.Hunk
+	if src.cell == 0 {
+		var err error
+		if hunks, err = f.repo.Hunks(f.base, config.Staged, c); err != nil {
+			return nil, fmt.Errorf("Cannot diff against %s: %s", f.base, err)
+		}
+	}
+	known, err := f.oldProblems(c)
+	if err != nil {
+		return nil, err
+	}
+	res := make([]parser.Diagnostic, 0, len(diagnostics))
+	for _, d := range diagnostics {
+		key := diagnosticKey(src.lines, d)
+		existed := known[key] > 0
+		if existed {
+			known[key]--
+		}
+		// The lines of notebook cells are not those of the diff, so the
+		// problems found in a cell before are taken as untouched.
+		if existed && (src.cell > 0 || !touched(hunks, d)) {
+			continue
+		}
+		res = append(res, d)
+	}
+	return res, nil
+}
+
+// oldProblems counts the problems of the old revision of a changed file by
+// their key.
+func (f *diffFilter) oldProblems(c git.Change) (map[string]int, error) {
+	oldPath := c.Path
+	if c.OldPath != "" {
+		oldPath = c.OldPath
+	}
+	if _, ok := f.known[oldPath]; !ok {
+		data, err := f.repo.Show(f.base, oldPath)
+		if err != nil {
+			return nil, fmt.Errorf("Cannot read revision %s: %s", f.base, err)
+		}
+		oldText, err := decodeSource(data)
+		if err != nil {
+			return nil, fmt.Errorf("Cannot decode revision %s: %s", f.base, err)
+		}
+		// A revision that cannot be checked has no known problems.
+		oldUnits, _ := checkText(oldPath, oldText.Text)
+		known := make(map[string]int)
+		for _, u := range oldUnits {
+			for _, d := range u.diagnostics {
+				known[diagnosticKey(u.lines, d)]++
+			}
+		}
+		f.known[oldPath] = known
+	}
+	res := make(map[string]int, len(f.known[oldPath]))
+	for k, n := range f.known[oldPath] {
+		res[k] = n
+	}
+	return res, nil
+}
+
+func touched(hunks []git.Hunk, d parser.Diagnostic) bool {
+	for _, h := range hunks {
+		if h.Contains(d.Line) || (d.Open != nil && h.Contains(d.Open.Line)) {
+			return true
+		}
+	}
+	return false
+}
+
+// diagnosticKey identifies a diagnostic by the text of the lines involved
+// rather than by their numbers, so that it can be matched across revisions.
+func diagnosticKey(lines []string, d parser.Diagnostic) string {
+	key := []string{string(d.Kind), d.Found, lineText(lines, d.Line)}
+	if d.Open != nil {
+		key = append(key, d.Open.String(), lineText(lines, d.Open.Line))
+	}
+	return strings.Join(key, "\x00")
+}
+
+func lineText(lines []string, n int) string {
+	if n < 1 || n > len(lines) {
+		return ""
+	}
+	return strings.TrimSpace(lines[n-1])
+}
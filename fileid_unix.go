@@ -0,0 +1,32 @@
+// Copyright (C) 2020 Fabio Del Vigna
+//
+// This file is part of drbracket.
+//
+// drbracket is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// drbracket is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with drbracket.  If not, see <http://www.gnu.org/licenses/>.
+
+//go:build unix
+
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+func getFileID(path string, info os.FileInfo) fileID {
+	if st, ok := info.Sys().(*syscall.Stat_t); ok {
+		return fileID{dev: uint64(st.Dev), ino: uint64(st.Ino)}
+	}
+	return fileID{path: absPath(path)}
+}
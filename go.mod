@@ -22,6 +22,5 @@ go 1.20
 require (
 	github.com/jessevdk/go-flags v1.5.0
 	github.com/sirupsen/logrus v1.9.0
+	golang.org/x/sys v0.5.0
 )
-
-require golang.org/x/sys v0.5.0 // indirect
@@ -0,0 +1,176 @@
+// Copyright (C) 2020 Fabio Del Vigna
+//
+// This file is part of drbracket.
+//
+// drbracket is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// drbracket is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with drbracket.  If not, see <http://www.gnu.org/licenses/>.
+
+package baseline
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/yoskini/drbracket/lib/parser"
+)
+
+const formatVersion = 1
+
+// contextLines is how many lines around a diagnostic go into its fingerprint.
+const contextLines = 1
+
+type Entry struct {
+	File        string `json:"file"`
+	Kind        string `json:"kind"`
+	Fingerprint string `json:"fingerprint"`
+	Line        int    `json:"line"`
+	Message     string `json:"message"`
+}
+
+func (e Entry) key() string {
+	return e.File + "\x00" + e.Kind + "\x00" + e.Fingerprint
+}
+
+type Baseline struct {
+	Version int     `json:"version"`
+	Entries []Entry `json:"entries"`
+
+	remaining map[string]int
+	matched   map[string]int
+}
+
+func New() *Baseline {
+	return &Baseline{
+		Version: formatVersion,
+		Entries: make([]Entry, 0),
+	}
+}
+
+func Load(path string) (*Baseline, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("Cannot read baseline %s: %s", path, err)
+	}
+	b := New()
+	if err := json.Unmarshal(data, b); err != nil {
+		return nil, fmt.Errorf("Cannot parse baseline %s: %s", path, err)
+	}
+	if b.Version != formatVersion {
+		return nil, fmt.Errorf("Unsupported baseline version %d in %s", b.Version, path)
+	}
+	b.remaining = make(map[string]int)
+	b.matched = make(map[string]int)
+	for _, e := range b.Entries {
+		b.remaining[e.key()]++
+	}
+	return b, nil
+}
+
+func (b *Baseline) Save(path string) error {
+	sort.SliceStable(b.Entries, func(i, j int) bool {
+		if b.Entries[i].File != b.Entries[j].File {
+			return b.Entries[i].File < b.Entries[j].File
+		}
+		return b.Entries[i].Line < b.Entries[j].Line
+	})
+	data, err := json.MarshalIndent(b, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(path, append(data, '\n'), 0644); err != nil {
+		return fmt.Errorf("Cannot write baseline %s: %s", path, err)
+	}
+	return nil
+}
+
+func NewEntry(file string, lines []string, d parser.Diagnostic) Entry {
+	return Entry{
+		File:        file,
+		Kind:        string(d.Kind),
+		Fingerprint: Fingerprint(lines, d),
+		Line:        d.Line,
+		Message:     d.Message,
+	}
+}
+
+func (b *Baseline) Add(e Entry) {
+	b.Entries = append(b.Entries, e)
+}
+
+// Match reports whether e is a known issue. Each baseline entry absorbs a
+// single diagnostic, so a second copy of a known problem is still new.
+func (b *Baseline) Match(e Entry) bool {
+	k := e.key()
+	if b.remaining[k] == 0 {
+		return false
+	}
+	b.remaining[k]--
+	b.matched[k]++
+	return true
+}
+
+// Rewind gives back the entries of file matched so far, so that the file can
+// be checked again.
+func (b *Baseline) Rewind(file string) {
+	for k, n := range b.matched {
+		if strings.HasPrefix(k, file+"\x00") {
+			b.remaining[k] += n
+			delete(b.matched, k)
+		}
+	}
+}
+
+// Stale returns the entries that were not matched by any diagnostic of the
+// files accepted by checked.
+func (b *Baseline) Stale(checked func(file string) bool) []Entry {
+	seen := make(map[string]int)
+	stale := make([]Entry, 0)
+	for _, e := range b.Entries {
+		k := e.key()
+		seen[k]++
+		if seen[k] > b.matched[k] && checked(e.File) {
+			stale = append(stale, e)
+		}
+	}
+	return stale
+}
+
+// Fingerprint hashes the diagnostic together with the text of the lines
+// around it and around its opener, ignoring indentation, so that it survives
+// code being moved up or down the file.
+func Fingerprint(lines []string, d parser.Diagnostic) string {
+	h := sha256.New()
+	fmt.Fprintf(h, "%s\x00%s\x00", d.Kind, d.Found)
+	writeContext(h, lines, d.Line)
+	if d.Open != nil {
+		fmt.Fprintf(h, "%s\x00", d.Open)
+		writeContext(h, lines, d.Open.Line)
+	}
+	return hex.EncodeToString(h.Sum(nil))[:16]
+}
+
+func writeContext(w io.Writer, lines []string, line int) {
+	for n := line - contextLines; n <= line+contextLines; n++ {
+		if n >= 1 && n <= len(lines) {
+			fmt.Fprintf(w, "%s\n", strings.TrimSpace(lines[n-1]))
+		} else {
+			fmt.Fprintf(w, "\n")
+		}
+	}
+}
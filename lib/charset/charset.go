@@ -0,0 +1,237 @@
+// Copyright (C) 2020 Fabio Del Vigna
+//
+// This file is part of drbracket.
+//
+// drbracket is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// drbracket is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with drbracket.  If not, see <http://www.gnu.org/licenses/>.
+
+package charset
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+const (
+	UTF8        = "utf-8"
+	UTF16LE     = "utf-16le"
+	UTF16BE     = "utf-16be"
+	Latin1      = "latin1"
+	Windows1252 = "windows-1252"
+)
+
+var (
+	bomUTF8    = []byte{0xEF, 0xBB, 0xBF}
+	bomUTF16LE = []byte{0xFF, 0xFE}
+	bomUTF16BE = []byte{0xFE, 0xFF}
+)
+
+var aliases = map[string]string{
+	"utf8":         UTF8,
+	"utf-8":        UTF8,
+	"utf16le":      UTF16LE,
+	"utf-16le":     UTF16LE,
+	"utf16be":      UTF16BE,
+	"utf-16be":     UTF16BE,
+	"latin1":       Latin1,
+	"latin-1":      Latin1,
+	"iso-8859-1":   Latin1,
+	"iso8859-1":    Latin1,
+	"cp1252":       Windows1252,
+	"windows-1252": Windows1252,
+}
+
+// Characters 0x80-0x9F of windows-1252, the rest of the table matches latin1.
+// Unassigned code points map to the replacement character.
+var windows1252 = [32]rune{
+	'€', '�', '‚', 'ƒ', '„', '…', '†', '‡',
+	'ˆ', '‰', 'Š', '‹', 'Œ', '�', 'Ž', '�',
+	'�', '‘', '’', '“', '”', '•', '–', '—',
+	'˜', '™', 'š', '›', 'œ', '�', 'ž', 'Ÿ',
+}
+
+type Position struct {
+	Line int
+	Col  int
+}
+
+type Text struct {
+	Text     string
+	Encoding string
+	BOM      bool
+	Invalid  []Position
+}
+
+func Normalize(name string) (string, error) {
+	if enc, ok := aliases[strings.ToLower(name)]; ok {
+		return enc, nil
+	}
+	return "", fmt.Errorf("Unknown encoding %s", name)
+}
+
+// Decode converts data to UTF-8. A byte order mark always wins; otherwise
+// valid UTF-8 is kept as is and anything else is decoded with fallback.
+func Decode(data []byte, fallback string) (*Text, error) {
+	enc, err := Normalize(fallback)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case bytes.HasPrefix(data, bomUTF8):
+		res := decodeUTF8(data[len(bomUTF8):])
+		res.BOM = true
+		return res, nil
+	case bytes.HasPrefix(data, bomUTF16LE):
+		res := decodeUTF16(data[len(bomUTF16LE):], false)
+		res.BOM = true
+		return res, nil
+	case bytes.HasPrefix(data, bomUTF16BE):
+		res := decodeUTF16(data[len(bomUTF16BE):], true)
+		res.BOM = true
+		return res, nil
+	}
+
+	// UTF-16 text is mostly valid UTF-8 as well, so its NUL bytes are
+	// looked at first.
+	switch guessUTF16(data) {
+	case UTF16LE:
+		return decodeUTF16(data, false), nil
+	case UTF16BE:
+		return decodeUTF16(data, true), nil
+	}
+	switch {
+	case utf8.Valid(data) || enc == UTF8:
+		return decodeUTF8(data), nil
+	case enc == UTF16LE:
+		return decodeUTF16(data, false), nil
+	case enc == UTF16BE:
+		return decodeUTF16(data, true), nil
+	}
+	return decodeSingleByte(data, enc), nil
+}
+
+// guessUTF16 recognises BOM-less UTF-16 by its NUL bytes, which never show up
+// in source code otherwise: ASCII text leaves them on every other byte.
+func guessUTF16(data []byte) string {
+	if len(data) < 2 || len(data)%2 != 0 {
+		return ""
+	}
+	even, odd := 0, 0
+	for i, b := range data {
+		if b != 0 {
+			continue
+		}
+		if i%2 == 0 {
+			even++
+		} else {
+			odd++
+		}
+	}
+	half := len(data) / 4
+	switch {
+	case odd > half && even == 0:
+		return UTF16LE
+	case even > half && odd == 0:
+		return UTF16BE
+	}
+	return ""
+}
+
+func decodeUTF8(data []byte) *Text {
+	res := &Text{Encoding: UTF8}
+	if utf8.Valid(data) {
+		res.Text = string(data)
+		return res
+	}
+	var sb strings.Builder
+	sb.Grow(len(data))
+	line, col := 1, 0
+	for len(data) > 0 {
+		r, size := utf8.DecodeRune(data)
+		data = data[size:]
+		col++
+		if r == utf8.RuneError && size == 1 {
+			res.Invalid = append(res.Invalid, Position{Line: line, Col: col})
+		}
+		if r == '\n' {
+			line++
+			col = 0
+		}
+		sb.WriteRune(r)
+	}
+	res.Text = sb.String()
+	return res
+}
+
+func decodeUTF16(data []byte, bigEndian bool) *Text {
+	res := &Text{Encoding: UTF16LE}
+	if bigEndian {
+		res.Encoding = UTF16BE
+	}
+	units := make([]uint16, 0, len(data)/2)
+	for i := 0; i+1 < len(data); i += 2 {
+		if bigEndian {
+			units = append(units, uint16(data[i])<<8|uint16(data[i+1]))
+		} else {
+			units = append(units, uint16(data[i+1])<<8|uint16(data[i]))
+		}
+	}
+	var sb strings.Builder
+	sb.Grow(len(data))
+	line, col := 1, 0
+	for i := 0; i < len(units); i++ {
+		r := rune(units[i])
+		col++
+		if utf16.IsSurrogate(r) {
+			r = utf8.RuneError
+			if i+1 < len(units) {
+				if dec := utf16.DecodeRune(rune(units[i]), rune(units[i+1])); dec != utf8.RuneError {
+					r = dec
+					i++
+				}
+			}
+			if r == utf8.RuneError {
+				res.Invalid = append(res.Invalid, Position{Line: line, Col: col})
+			}
+		}
+		if r == '\n' {
+			line++
+			col = 0
+		}
+		sb.WriteRune(r)
+	}
+	if len(data)%2 != 0 {
+		res.Invalid = append(res.Invalid, Position{Line: line, Col: col + 1})
+		sb.WriteRune(utf8.RuneError)
+	}
+	res.Text = sb.String()
+	return res
+}
+
+func decodeSingleByte(data []byte, enc string) *Text {
+	res := &Text{Encoding: enc}
+	var sb strings.Builder
+	sb.Grow(len(data) * 2)
+	for _, b := range data {
+		r := rune(b)
+		if enc == Windows1252 && b >= 0x80 && b <= 0x9F {
+			r = windows1252[b-0x80]
+		}
+		sb.WriteRune(r)
+	}
+	res.Text = sb.String()
+	return res
+}
@@ -0,0 +1,217 @@
+// Copyright (C) 2020 Fabio Del Vigna
+//
+// This file is part of drbracket.
+//
+// drbracket is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// drbracket is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with drbracket.  If not, see <http://www.gnu.org/licenses/>.
+
+package git
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+const (
+	StatusAdded    = 'A'
+	StatusCopied   = 'C'
+	StatusDeleted  = 'D'
+	StatusModified = 'M'
+	StatusRenamed  = 'R'
+	StatusType     = 'T'
+)
+
+type Change struct {
+	Status  byte
+	Path    string
+	OldPath string
+}
+
+type Repo struct {
+	Root string
+	dir  string
+}
+
+func run(dir string, args ...string) ([]byte, error) {
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return nil, fmt.Errorf("git %s: %s", args[0], msg)
+		}
+		return nil, fmt.Errorf("git %s: %s", args[0], err)
+	}
+	return out, nil
+}
+
+func Open(dir string) (*Repo, error) {
+	out, err := run(dir, "rev-parse", "--show-toplevel")
+	if err != nil {
+		return nil, fmt.Errorf("Cannot find git repository in %s: %s", dir, err)
+	}
+	return &Repo{Root: strings.TrimSpace(string(out)), dir: dir}, nil
+}
+
+// MergeBase returns the commit where HEAD forked from rev, so that what
+// rev gained since is not taken for changes of the branch.
+func (r *Repo) MergeBase(rev string) (string, error) {
+	out, err := run(r.dir, "merge-base", rev, "HEAD")
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// ChangedSince lists the files that differ between rev and the working tree.
+// Pathspecs are relative to the directory the repository was opened from.
+func (r *Repo) ChangedSince(rev string, pathspecs []string) ([]Change, error) {
+	return r.diff(append([]string{rev, "--"}, pathspecs...))
+}
+
+// Staged lists the files whose changes are staged in the index.
+func (r *Repo) Staged(pathspecs []string) ([]Change, error) {
+	return r.diff(append([]string{"--cached", "--"}, pathspecs...))
+}
+
+func (r *Repo) diff(args []string) ([]Change, error) {
+	args = append([]string{"diff", "--name-status", "-z", "-M", "--no-ext-diff"}, args...)
+	out, err := run(r.dir, args...)
+	if err != nil {
+		return nil, err
+	}
+	return parseNameStatus(r.Root, out)
+}
+
+// parseNameStatus decodes `git diff --name-status -z`, where renames and
+// copies carry a similarity score and two paths instead of one.
+func parseNameStatus(root string, out []byte) ([]Change, error) {
+	fields := strings.Split(strings.TrimSuffix(string(out), "\x00"), "\x00")
+	changes := make([]Change, 0)
+	for i := 0; i < len(fields); i++ {
+		status := fields[i]
+		if status == "" {
+			continue
+		}
+		c := Change{Status: status[0]}
+		switch c.Status {
+		case StatusRenamed, StatusCopied:
+			if i+2 >= len(fields) {
+				return nil, fmt.Errorf("Truncated git diff output")
+			}
+			c.OldPath = filepath.Join(root, filepath.FromSlash(fields[i+1]))
+			c.Path = filepath.Join(root, filepath.FromSlash(fields[i+2]))
+			i += 2
+		default:
+			if i+1 >= len(fields) {
+				return nil, fmt.Errorf("Truncated git diff output")
+			}
+			c.Path = filepath.Join(root, filepath.FromSlash(fields[i+1]))
+			i++
+		}
+		changes = append(changes, c)
+	}
+	return changes, nil
+}
+
+func (r *Repo) relative(path string) (string, error) {
+	rel, err := filepath.Rel(r.Root, path)
+	if err != nil || strings.HasPrefix(rel, "..") {
+		return "", fmt.Errorf("Path %s is outside the repository %s", path, r.Root)
+	}
+	return filepath.ToSlash(rel), nil
+}
+
+// Show returns the content of the file at path as it was in rev, or as it
+// is staged in the index if rev is empty.
+func (r *Repo) Show(rev, path string) ([]byte, error) {
+	rel, err := r.relative(path)
+	if err != nil {
+		return nil, err
+	}
+	return run(r.Root, "show", rev+":"+rel)
+}
+
+// Hunk is a range of lines of the new side of a diff. Count is zero when
+// lines were only removed, in which case Start is the line before the removal.
+type Hunk struct {
+	Start int
+	Count int
+}
+
+func (h Hunk) Contains(line int) bool {
+	if h.Count == 0 {
+		return line == h.Start || line == h.Start+1
+	}
+	return line >= h.Start && line < h.Start+h.Count
+}
+
+// Hunks returns the ranges of lines of the working tree copy of c that differ
+// from rev, or of its staged copy if cached is set.
+func (r *Repo) Hunks(rev string, cached bool, c Change) ([]Hunk, error) {
+	args := []string{"diff", "-U0", "-M", "--no-ext-diff", "--no-color"}
+	if cached {
+		args = append(args, "--cached")
+	}
+	args = append(args, rev, "--")
+	for _, p := range []string{c.OldPath, c.Path} {
+		if p == "" {
+			continue
+		}
+		rel, err := r.relative(p)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, rel)
+	}
+	out, err := run(r.Root, args...)
+	if err != nil {
+		return nil, err
+	}
+	return parseHunks(out)
+}
+
+// parseHunks reads the new side ranges out of "@@ -a,b +c,d @@" headers.
+func parseHunks(out []byte) ([]Hunk, error) {
+	hunks := make([]Hunk, 0)
+	for _, line := range strings.Split(string(out), "\n") {
+		if !strings.HasPrefix(line, "@@ ") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) < 3 || !strings.HasPrefix(fields[2], "+") {
+			return nil, fmt.Errorf("Malformed hunk header %q", line)
+		}
+		h := Hunk{Count: 1}
+		var err error
+		if start, count, ok := strings.Cut(fields[2][1:], ","); ok {
+			h.Start, err = strconv.Atoi(start)
+			if err == nil {
+				h.Count, err = strconv.Atoi(count)
+			}
+		} else {
+			h.Start, err = strconv.Atoi(start)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("Malformed hunk header %q", line)
+		}
+		hunks = append(hunks, h)
+	}
+	return hunks, nil
+}
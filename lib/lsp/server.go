@@ -0,0 +1,314 @@
+// Copyright (C) 2020 Fabio Del Vigna
+//
+// This file is part of drbracket.
+//
+// drbracket is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// drbracket is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with drbracket.  If not, see <http://www.gnu.org/licenses/>.
+
+package lsp
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/textproto"
+	"strconv"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+	"github.com/yoskini/drbracket/lib/parser"
+)
+
+type Server struct {
+	version      string
+	reportUnused bool
+	docs         map[string]*document
+	out          io.Writer
+	shutdown     bool
+}
+
+func NewServer(version string, reportUnused bool) *Server {
+	return &Server{
+		version:      version,
+		reportUnused: reportUnused,
+		docs:         make(map[string]*document),
+	}
+}
+
+// Serve speaks the Language Server Protocol over in and out until the client
+// sends the exit notification or closes the stream.
+func (s *Server) Serve(in io.Reader, out io.Writer) error {
+	s.out = out
+	r := bufio.NewReader(in)
+	for {
+		body, err := readMessage(r)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		var req request
+		if err := json.Unmarshal(body, &req); err != nil {
+			s.reply(nil, nil, &responseError{Code: errParse, Message: err.Error()})
+			continue
+		}
+		if req.Method == "exit" {
+			if !s.shutdown {
+				return fmt.Errorf("Exit requested before shutdown")
+			}
+			return nil
+		}
+		result, rerr := s.handle(&req)
+		if req.ID != nil {
+			s.reply(req.ID, result, rerr)
+		} else if rerr != nil {
+			logrus.Warnf("%s: %s", req.Method, rerr.Message)
+		}
+	}
+}
+
+func readMessage(r *bufio.Reader) ([]byte, error) {
+	header, err := textproto.NewReader(r).ReadMIMEHeader()
+	if err != nil {
+		if len(header) == 0 && (err == io.EOF || err == io.ErrUnexpectedEOF) {
+			return nil, io.EOF
+		}
+		return nil, fmt.Errorf("Cannot read message header: %s", err)
+	}
+	length, err := strconv.Atoi(header.Get("Content-Length"))
+	if err != nil || length < 0 {
+		return nil, fmt.Errorf("Invalid Content-Length %q", header.Get("Content-Length"))
+	}
+	body := make([]byte, length)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return nil, fmt.Errorf("Cannot read message body: %s", err)
+	}
+	return body, nil
+}
+
+func (s *Server) write(msg interface{}) {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		logrus.Errorf("Cannot encode message: %s", err)
+		return
+	}
+	if _, err := fmt.Fprintf(s.out, "Content-Length: %d\r\n\r\n%s", len(data), data); err != nil {
+		logrus.Errorf("Cannot write message: %s", err)
+	}
+}
+
+func (s *Server) reply(id *json.RawMessage, result interface{}, rerr *responseError) {
+	resp := response{JSONRPC: "2.0", ID: id, Error: rerr}
+	if rerr == nil {
+		data, err := json.Marshal(result)
+		if err != nil {
+			resp.Error = &responseError{Code: errInvalidRequest, Message: err.Error()}
+		} else {
+			resp.Result = data
+		}
+	}
+	s.write(resp)
+}
+
+func (s *Server) notify(method string, params interface{}) {
+	s.write(notification{JSONRPC: "2.0", Method: method, Params: params})
+}
+
+func decode(params json.RawMessage, v interface{}) *responseError {
+	if err := json.Unmarshal(params, v); err != nil {
+		return &responseError{Code: errInvalidParams, Message: err.Error()}
+	}
+	return nil
+}
+
+func (s *Server) handle(req *request) (interface{}, *responseError) {
+	switch req.Method {
+	case "initialize":
+		res := initializeResult{ServerInfo: serverInfo{Name: "drbracket", Version: s.version}}
+		res.Capabilities.TextDocumentSync.OpenClose = true
+		res.Capabilities.TextDocumentSync.Change = syncIncremental
+		res.Capabilities.CodeActionProvider = true
+		return res, nil
+	case "shutdown":
+		s.shutdown = true
+		return nil, nil
+	case "textDocument/didOpen":
+		var params didOpenParams
+		if err := decode(req.Params, &params); err != nil {
+			return nil, err
+		}
+		doc := &document{
+			uri:     params.TextDocument.URI,
+			path:    uriToPath(params.TextDocument.URI),
+			version: params.TextDocument.Version,
+		}
+		doc.open(params.TextDocument.Text)
+		s.docs[doc.uri] = doc
+		s.publish(doc)
+		return nil, nil
+	case "textDocument/didChange":
+		var params didChangeParams
+		if err := decode(req.Params, &params); err != nil {
+			return nil, err
+		}
+		doc, ok := s.docs[params.TextDocument.URI]
+		if !ok {
+			return nil, &responseError{Code: errInvalidParams, Message: "Unknown document " + params.TextDocument.URI}
+		}
+		for _, change := range params.ContentChanges {
+			doc.apply(change)
+		}
+		doc.version = params.TextDocument.Version
+		s.publish(doc)
+		return nil, nil
+	case "textDocument/didClose":
+		var params didCloseParams
+		if err := decode(req.Params, &params); err != nil {
+			return nil, err
+		}
+		delete(s.docs, params.TextDocument.URI)
+		s.notify("textDocument/publishDiagnostics", publishDiagnosticsParams{
+			URI:         params.TextDocument.URI,
+			Diagnostics: []diagnostic{},
+		})
+		return nil, nil
+	case "textDocument/codeAction":
+		var params codeActionParams
+		if err := decode(req.Params, &params); err != nil {
+			return nil, err
+		}
+		doc, ok := s.docs[params.TextDocument.URI]
+		if !ok {
+			return []codeAction{}, nil
+		}
+		return doc.codeActions(params.Range), nil
+	}
+	if req.ID != nil && !strings.HasPrefix(req.Method, "$/") {
+		return nil, &responseError{Code: errMethodNotFound, Message: "Method not found: " + req.Method}
+	}
+	return nil, nil
+}
+
+func (doc *document) open(text string) {
+	doc.parsed = parser.NewDocument(parser.LanguageForFile(doc.path), text)
+}
+
+// apply updates the document with a change. Ranged changes are re-parsed
+// incrementally, the others replace the whole text.
+func (doc *document) apply(change contentChange) {
+	if change.Range == nil {
+		doc.open(change.Text)
+		return
+	}
+	start, end := change.Range.Start, change.Range.End
+	if before(end, start) {
+		start, end = end, start
+	}
+	startCol := column(doc.parsed.Line(start.Line+1), start.Character)
+	endCol := column(doc.parsed.Line(end.Line+1), end.Character)
+	doc.parsed.ApplyEdit(parser.Edit{
+		StartLine: start.Line + 1,
+		StartCol:  startCol,
+		EndLine:   end.Line + 1,
+		EndCol:    endCol,
+		Text:      change.Text,
+	})
+}
+
+func (s *Server) publish(doc *document) {
+	doc.diagnostics = doc.parsed.Diagnostics()
+	if s.reportUnused {
+		doc.diagnostics = append(doc.diagnostics, doc.parsed.UnusedSuppressions()...)
+	}
+	lines := doc.parsed.Lines()
+	params := publishDiagnosticsParams{
+		URI:         doc.uri,
+		Version:     &doc.version,
+		Diagnostics: make([]diagnostic, 0, len(doc.diagnostics)),
+	}
+	for _, d := range doc.diagnostics {
+		params.Diagnostics = append(params.Diagnostics, doc.convert(lines, d))
+	}
+	s.notify("textDocument/publishDiagnostics", params)
+}
+
+func (doc *document) convert(lines []string, d parser.Diagnostic) diagnostic {
+	res := diagnostic{
+		Range:    span(lines, d.Line, d.Col, d.Found),
+		Severity: severityError,
+		Code:     string(d.Kind),
+		Source:   "drbracket",
+		Message:  d.Message,
+	}
+	if d.Severity == parser.SeverityWarning {
+		res.Severity = severityWarning
+	}
+	if d.Open != nil && d.Kind != parser.DiagnosticUnclosed {
+		res.RelatedInformation = []diagnosticRelatedInformation{{
+			Location: location{URI: doc.uri, Range: span(lines, d.Open.Line, d.Open.Col, d.Open.String())},
+			Message:  fmt.Sprintf("Opening %s", d.Open),
+		}}
+	}
+	return res
+}
+
+// codeActions returns the quick fixes for the diagnostics that overlap r.
+func (doc *document) codeActions(r Range) []codeAction {
+	lines := doc.parsed.Lines()
+	actions := make([]codeAction, 0)
+	for _, d := range doc.diagnostics {
+		diag := doc.convert(lines, d)
+		if !overlaps(diag.Range, r) {
+			continue
+		}
+		for _, fix := range fixes(doc.uri, lines, d, diag.Range) {
+			fix.Diagnostics = []diagnostic{diag}
+			actions = append(actions, fix)
+		}
+	}
+	return actions
+}
+
+// fixes suggests edits for d, whose range in the document is found.
+func fixes(uri string, lines []string, d parser.Diagnostic, found Range) []codeAction {
+	edit := func(title string, preferred bool, r Range, text string) codeAction {
+		return codeAction{
+			Title:       title,
+			Kind:        "quickfix",
+			IsPreferred: preferred,
+			Edit:        workspaceEdit{Changes: map[string][]textEdit{uri: {{Range: r, NewText: text}}}},
+		}
+	}
+	switch d.Kind {
+	case parser.DiagnosticMismatched:
+		closer := d.Open.Closer()
+		insert := Range{Start: found.Start, End: found.Start}
+		separator := ""
+		if d.Open.Noun() == "block" {
+			separator = " "
+		}
+		return []codeAction{
+			edit(fmt.Sprintf("Replace %s with %s", d.Found, closer), d.Open.Line == d.Line, found, closer),
+			edit(fmt.Sprintf("Insert %s before %s", closer, d.Found), d.Open.Line != d.Line, insert, closer+separator),
+		}
+	case parser.DiagnosticUnexpected:
+		return []codeAction{edit(fmt.Sprintf("Remove %s", d.Found), true, found, "")}
+	case parser.DiagnosticUnclosed, parser.DiagnosticUnclosedForm:
+		end := endOfDocument(lines)
+		closer := d.Open.Closer()
+		return []codeAction{edit(fmt.Sprintf("Insert missing %s at end of file", closer), true, Range{Start: end, End: end}, closer)}
+	}
+	return nil
+}
@@ -0,0 +1,109 @@
+// Copyright (C) 2020 Fabio Del Vigna
+//
+// This file is part of drbracket.
+//
+// drbracket is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// drbracket is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with drbracket.  If not, see <http://www.gnu.org/licenses/>.
+
+package lsp
+
+import (
+	"net/url"
+	"path/filepath"
+
+	"github.com/yoskini/drbracket/lib/parser"
+)
+
+type document struct {
+	uri         string
+	path        string
+	version     int
+	parsed      *parser.Document
+	diagnostics []parser.Diagnostic
+}
+
+func uriToPath(uri string) string {
+	u, err := url.Parse(uri)
+	if err != nil || u.Scheme != "file" {
+		return uri
+	}
+	return filepath.FromSlash(u.Path)
+}
+
+func utf16Len(r rune) int {
+	if r >= 0x10000 {
+		return 2
+	}
+	return 1
+}
+
+// column converts a character offset in line, counted in UTF-16 code units as
+// in LSP positions, to a 1-based rune column.
+func column(line string, character int) int {
+	col, units := 1, 0
+	for _, r := range line {
+		if units >= character {
+			break
+		}
+		units += utf16Len(r)
+		col++
+	}
+	return col
+}
+
+// position converts a 1-based line and rune column, as used by the parser,
+// to an LSP position.
+func position(lines []string, line, col int) Position {
+	pos := Position{Line: line - 1}
+	if line < 1 || line > len(lines) {
+		return pos
+	}
+	n := 1
+	for _, r := range lines[line-1] {
+		if n >= col {
+			break
+		}
+		pos.Character += utf16Len(r)
+		n++
+	}
+	return pos
+}
+
+func span(lines []string, line, col int, text string) Range {
+	start := position(lines, line, col)
+	end := start
+	for _, r := range text {
+		end.Character += utf16Len(r)
+	}
+	if text == "" {
+		end.Character++
+	}
+	return Range{Start: start, End: end}
+}
+
+func endOfDocument(lines []string) Position {
+	last := len(lines) - 1
+	pos := Position{Line: last}
+	for _, r := range lines[last] {
+		pos.Character += utf16Len(r)
+	}
+	return pos
+}
+
+func overlaps(a, b Range) bool {
+	return !before(a.End, b.Start) && !before(b.End, a.Start)
+}
+
+func before(a, b Position) bool {
+	return a.Line < b.Line || (a.Line == b.Line && a.Character < b.Character)
+}
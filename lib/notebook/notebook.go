@@ -0,0 +1,112 @@
+// Copyright (C) 2020 Fabio Del Vigna
+//
+// This file is part of drbracket.
+//
+// drbracket is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// drbracket is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with drbracket.  If not, see <http://www.gnu.org/licenses/>.
+
+package notebook
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// Cell is a code cell of a notebook. Index counts every cell of the
+// notebook from 1, and Language is set when a cell magic such as %%bash
+// switches the cell to another language than the kernel's.
+type Cell struct {
+	Index    int
+	Language string
+	Source   string
+}
+
+type notebook struct {
+	Metadata struct {
+		Kernelspec struct {
+			Language string `json:"language"`
+		} `json:"kernelspec"`
+		LanguageInfo struct {
+			Name string `json:"name"`
+		} `json:"language_info"`
+	} `json:"metadata"`
+	Cells []struct {
+		CellType string `json:"cell_type"`
+		Source   source `json:"source"`
+	} `json:"cells"`
+}
+
+// source is the source of a cell, which is written either as a string or
+// as a list of lines that keep their line endings.
+type source string
+
+func (s *source) UnmarshalJSON(data []byte) error {
+	var lines []string
+	if err := json.Unmarshal(data, &lines); err == nil {
+		*s = source(strings.Join(lines, ""))
+		return nil
+	}
+	var text string
+	if err := json.Unmarshal(data, &text); err != nil {
+		return fmt.Errorf("Invalid cell source: %s", err)
+	}
+	*s = source(text)
+	return nil
+}
+
+// Read returns the language of the kernel of a notebook, empty if the
+// notebook does not tell, and its code cells.
+func Read(data []byte) (string, []Cell, error) {
+	var nb notebook
+	if err := json.Unmarshal(data, &nb); err != nil {
+		return "", nil, fmt.Errorf("Invalid notebook: %s", err)
+	}
+	lang := nb.Metadata.Kernelspec.Language
+	if lang == "" {
+		lang = nb.Metadata.LanguageInfo.Name
+	}
+	cells := make([]Cell, 0, len(nb.Cells))
+	for i, c := range nb.Cells {
+		if c.CellType != "code" {
+			continue
+		}
+		cell := Cell{Index: i + 1, Source: string(c.Source)}
+		if strings.EqualFold(lang, "python") {
+			cell.Language, cell.Source = magics(cell.Source)
+		}
+		cells = append(cells, cell)
+	}
+	return lang, cells, nil
+}
+
+// magics blanks the IPython magics and shell escapes of a Python cell,
+// which are not Python, keeping its lines in place. A cell magic names the
+// language of the rest of the cell.
+func magics(src string) (string, string) {
+	lines := strings.Split(src, "\n")
+	lang := ""
+	for i, line := range lines {
+		trimmed := strings.TrimSpace(line)
+		switch {
+		case i == 0 && strings.HasPrefix(trimmed, "%%"):
+			if fields := strings.Fields(trimmed[2:]); len(fields) > 0 {
+				lang = fields[0]
+			}
+			lines[i] = ""
+		case lang == "" && (strings.HasPrefix(trimmed, "%") || strings.HasPrefix(trimmed, "!")):
+			lines[i] = ""
+		}
+	}
+	return lang, strings.Join(lines, "\n")
+}
@@ -0,0 +1,183 @@
+// Copyright (C) 2020 Fabio Del Vigna
+//
+// This file is part of drbracket.
+//
+// drbracket is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// drbracket is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with drbracket.  If not, see <http://www.gnu.org/licenses/>.
+
+package parser
+
+import (
+	"fmt"
+)
+
+type DiagnosticKind string
+
+const (
+	DiagnosticMismatched   DiagnosticKind = "mismatched"
+	DiagnosticUnexpected   DiagnosticKind = "unexpected"
+	DiagnosticUnclosed     DiagnosticKind = "unclosed"
+	DiagnosticUnclosedForm DiagnosticKind = "unclosed-form"
+
+	DiagnosticUnterminatedInterpolation DiagnosticKind = "unterminated-interpolation"
+	DiagnosticUnclosedComment           DiagnosticKind = "unclosed-comment"
+	DiagnosticUnclosedString            DiagnosticKind = "unclosed-string"
+
+	DiagnosticUnusedSuppression DiagnosticKind = "unused-suppression"
+	DiagnosticInvalidEncoding   DiagnosticKind = "invalid-encoding"
+)
+
+type Severity int
+
+const (
+	SeverityError Severity = iota
+	SeverityWarning
+)
+
+func (s Severity) String() string {
+	if s == SeverityWarning {
+		return "warning"
+	}
+	return "error"
+}
+
+type Diagnostic struct {
+	Kind     DiagnosticKind
+	Severity Severity
+	Line     int
+	Col      int
+	Found    string
+	Open     *Bracket
+	// Form is the head of the top-level form left open by an unclosed-form
+	// diagnostic, as (defn name.
+	Form    string
+	Message string
+}
+
+func (d *Diagnostic) Error() string {
+	return d.Message
+}
+
+// describe builds the message of the diagnostic out of its fields.
+func (d *Diagnostic) describe() *Diagnostic {
+	switch d.Kind {
+	case DiagnosticMismatched:
+		d.Message = fmt.Sprintf("Unbalanced %s. Found %s at line: %d, col: %d. Expected %s from line: %d, col: %d",
+			d.Open.Noun(), d.Found, d.Line, d.Col, d.Open, d.Open.Line, d.Open.Col)
+	case DiagnosticUnexpected:
+		d.Message = fmt.Sprintf("Unexpected %s at line: %d, col: %d. No bracket is open", d.Found, d.Line, d.Col)
+	case DiagnosticUnclosed:
+		d.Message = fmt.Sprintf("Unclosed %s %s at line: %d, col: %d", d.Open, d.Open.Noun(), d.Open.Line, d.Open.Col)
+	case DiagnosticUnclosedForm:
+		d.Message = fmt.Sprintf("Unclosed form %s at line: %d, col: %d", d.Form, d.Open.Line, d.Open.Col)
+	case DiagnosticUnterminatedInterpolation:
+		d.Message = fmt.Sprintf("Unterminated interpolation %s at line: %d, col: %d", d.Found, d.Line, d.Col)
+	case DiagnosticUnclosedComment:
+		d.Message = fmt.Sprintf("Unclosed comment %s at line: %d, col: %d", d.Found, d.Line, d.Col)
+	case DiagnosticUnclosedString:
+		d.Message = fmt.Sprintf("Unclosed string %s at line: %d, col: %d", d.Found, d.Line, d.Col)
+	case DiagnosticUnusedSuppression:
+		d.Message = fmt.Sprintf("Unused %s suppression at line: %d, col: %d", d.Found, d.Line, d.Col)
+	case DiagnosticInvalidEncoding:
+		d.Message = fmt.Sprintf("Invalid %s sequence at line: %d, col: %d", d.Found, d.Line, d.Col)
+	}
+	return d
+}
+
+// shift moves the lines after line by delta, as when lines are inserted or
+// removed above the diagnostic.
+func (d Diagnostic) shift(line, delta int) Diagnostic {
+	if d.Line > line {
+		d.Line += delta
+	}
+	if d.Open != nil && d.Open.Line > line {
+		open := *d.Open
+		open.Line += delta
+		d.Open = &open
+	}
+	return *d.describe()
+}
+
+func bracketError(found, open Bracket) *Diagnostic {
+	d := &Diagnostic{
+		Kind:  DiagnosticMismatched,
+		Line:  found.Line,
+		Col:   found.Col,
+		Found: found.String(),
+		Open:  &open,
+	}
+	return d.describe()
+}
+
+func unexpectedError(found Bracket) *Diagnostic {
+	d := &Diagnostic{
+		Kind:  DiagnosticUnexpected,
+		Line:  found.Line,
+		Col:   found.Col,
+		Found: found.String(),
+	}
+	return d.describe()
+}
+
+func unclosedError(open Bracket) *Diagnostic {
+	d := &Diagnostic{
+		Kind:  DiagnosticUnclosed,
+		Line:  open.Line,
+		Col:   open.Col,
+		Found: open.String(),
+		Open:  &open,
+	}
+	return d.describe()
+}
+
+func unterminatedError(f lexFrame) *Diagnostic {
+	d := &Diagnostic{
+		Kind:  DiagnosticUnterminatedInterpolation,
+		Line:  f.line,
+		Col:   f.col,
+		Found: f.open,
+	}
+	return d.describe()
+}
+
+// unclosedLiteralError reports the comment or string literal f, which the
+// text ends in.
+func unclosedLiteralError(kind DiagnosticKind, f lexFrame) *Diagnostic {
+	d := &Diagnostic{
+		Kind:  kind,
+		Line:  f.line,
+		Col:   f.col,
+		Found: f.open,
+	}
+	return d.describe()
+}
+
+// EncodingError warns about bytes at line and col that are not valid in
+// encoding, which were decoded as replacement characters.
+func EncodingError(encoding string, line, col int) Diagnostic {
+	d := &Diagnostic{
+		Kind:     DiagnosticInvalidEncoding,
+		Severity: SeverityWarning,
+		Line:     line,
+		Col:      col,
+		Found:    encoding,
+	}
+	return *d.describe()
+}
+
+func unclosedFormError(open Bracket, form string) *Diagnostic {
+	d := unclosedError(open)
+	d.Kind = DiagnosticUnclosedForm
+	d.Form = form
+	return d.describe()
+}
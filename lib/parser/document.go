@@ -0,0 +1,435 @@
+// Copyright (C) 2020 Fabio Del Vigna
+//
+// This file is part of drbracket.
+//
+// drbracket is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// drbracket is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with drbracket.  If not, see <http://www.gnu.org/licenses/>.
+
+package parser
+
+import (
+	"strings"
+)
+
+// Edit replaces the text between two positions, given as 1-based lines and
+// rune columns with the end excluded, by Text.
+type Edit struct {
+	StartLine int
+	StartCol  int
+	EndLine   int
+	EndCol    int
+	Text      string
+}
+
+// lineState is the parser state at a line boundary.
+type lineState struct {
+	stack  []Bracket
+	shared []Bracket
+	sup    suppressionState
+	lex    lexState
+}
+
+func (s lineState) equal(o lineState, line, delta int) bool {
+	if !s.sup.equal(o.sup, line, delta) || !s.lex.equal(o.lex, line, delta) {
+		return false
+	}
+	return shiftedStack(s.stack, o.stack, line, delta) && shiftedStack(s.shared, o.shared, line, delta)
+}
+
+// shiftedStack tells whether a is the stack b once shifted.
+func shiftedStack(a, b []Bracket, line, delta int) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i, x := range a {
+		if x != b[i].shift(line, delta) {
+			return false
+		}
+	}
+	return true
+}
+
+func (s lineState) shift(line, delta int) lineState {
+	res := lineState{sup: s.sup.shift(line, delta), lex: s.lex.shift(line, delta)}
+	res.stack = make([]Bracket, len(s.stack))
+	for i, b := range s.stack {
+		res.stack[i] = b.shift(line, delta)
+	}
+	if len(s.shared) > 0 {
+		res.shared = make([]Bracket, len(s.shared))
+		for i, b := range s.shared {
+			res.shared[i] = b.shift(line, delta)
+		}
+	}
+	return res
+}
+
+func (b Bracket) shift(line, delta int) Bracket {
+	if b.Line > line {
+		b.Line += delta
+	}
+	return b
+}
+
+type lineResult struct {
+	diagnostics  []Diagnostic
+	directives   []Directive
+	started      []Directive
+	suppressedBy *Directive
+	// lexed is the line with comments and literals blanked out, kept for
+	// suppressed lines only.
+	lexed lexedLine
+}
+
+func (r lineResult) shift(line, delta int) lineResult {
+	res := lineResult{suppressedBy: shiftDirective(r.suppressedBy, line, delta), lexed: r.lexed}
+	for _, d := range r.diagnostics {
+		res.diagnostics = append(res.diagnostics, d.shift(line, delta))
+	}
+	for _, d := range r.directives {
+		res.directives = append(res.directives, *shiftDirective(&d, line, delta))
+	}
+	for _, d := range r.started {
+		res.started = append(res.started, *shiftDirective(&d, line, delta))
+	}
+	return res
+}
+
+// Document checks a whole text line by line, applying the comment and
+// suppression rules of its language. The parser state is checkpointed at
+// every line boundary so that after an edit only the lines from the edit up
+// to the point where the state matches the old one again are parsed.
+type Document struct {
+	lang    *Language
+	lines   []string
+	states  []lineState
+	results []lineResult
+	parser  *BracketParser
+	sup     suppressionState
+	lex     lexState
+}
+
+func NewDocument(lang *Language, text string) *Document {
+	if lang == nil {
+		lang = LanguageDefault
+	}
+	doc := &Document{
+		lang:   lang,
+		lines:  SplitLines(text),
+		parser: NewBracketParser(),
+	}
+	doc.states = append(make([]lineState, 0, len(doc.lines)+1), lineState{})
+	doc.results = make([]lineResult, 0, len(doc.lines))
+	doc.parseFrom(1, nil)
+	return doc
+}
+
+// SplitLines splits text into lines, dropping the carriage return of CRLF
+// line endings. A text ending with a newline has an empty last line, which
+// keeps line numbers in step with those of editors.
+func SplitLines(text string) []string {
+	lines := strings.Split(text, "\n")
+	for i, l := range lines {
+		lines[i] = strings.TrimSuffix(l, "\r")
+	}
+	return lines
+}
+
+func (doc *Document) Language() *Language {
+	return doc.lang
+}
+
+// Lines returns the lines of the document. The slice is only valid until the
+// next edit.
+func (doc *Document) Lines() []string {
+	return doc.lines
+}
+
+// Line returns the 1-based line n, or an empty string past the end.
+func (doc *Document) Line(n int) string {
+	if n < 1 || n > len(doc.lines) {
+		return ""
+	}
+	return doc.lines[n-1]
+}
+
+func (doc *Document) snapshot(prev lineState) lineState {
+	state := lineState{stack: prev.stack, shared: prev.shared, sup: doc.sup, lex: prev.lex}
+	if !state.lex.equal(doc.lex, 0, 0) {
+		state.lex = doc.lex.clone()
+	}
+	if len(prev.stack) != len(doc.parser.stack) || !sameStack(prev.stack, doc.parser.stack) {
+		state.stack = append([]Bracket(nil), doc.parser.stack...)
+	}
+	if len(prev.shared) != len(doc.parser.shared) || !sameStack(prev.shared, doc.parser.shared) {
+		state.shared = append([]Bracket(nil), doc.parser.shared...)
+	}
+	return state
+}
+
+func sameStack(a, b []Bracket) bool {
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// parseFrom parses the lines from line from on, starting from the state
+// recorded before it. After every line converged is given the new state
+// before the next one, and parsing stops when it tells that the rest of the
+// document is still valid: the next line is then returned, or 0 if the
+// document was parsed to the end.
+func (doc *Document) parseFrom(from int, converged func(n int, state lineState) bool) int {
+	prev := doc.states[from-1]
+	doc.parser.stack = append(doc.parser.stack[:0], prev.stack...)
+	doc.parser.shared = append(doc.parser.shared[:0], prev.shared...)
+	doc.sup = prev.sup
+	doc.lex = prev.lex.clone()
+	for n := from; n <= len(doc.lines); n++ {
+		res := doc.parseLine(n)
+		state := doc.snapshot(prev)
+		stop := n < len(doc.lines) && converged != nil && converged(n+1, state)
+		if n-1 < len(doc.results) {
+			doc.results[n-1] = res
+		} else {
+			doc.results = append(doc.results, res)
+		}
+		if n < len(doc.states) {
+			doc.states[n] = state
+		} else {
+			doc.states = append(doc.states, state)
+		}
+		if stop {
+			return n + 1
+		}
+		prev = state
+	}
+	return 0
+}
+
+func (doc *Document) parseLine(n int) lineResult {
+	lexed, parsed := doc.lang.lex(&doc.lex, n, doc.lines[n-1])
+	res := lineResult{directives: directives(n, lexed.comments)}
+	res.suppressedBy, res.started = doc.sup.apply(res.directives)
+	if res.suppressedBy != nil {
+		res.lexed = lexed
+		return res
+	}
+	if !parsed {
+		return res
+	}
+	doc.parser.diagnostics = nil
+	doc.lang.parseCode(doc.parser, n, lexed)
+	res.diagnostics = append(doc.parser.diagnostics, lexed.diagnostics...)
+	doc.parser.diagnostics = nil
+	return res
+}
+
+// lex masks line n and tells whether its code is to be parsed. The lexer
+// blanks the comments of known languages, while for LanguageDefault the
+// lines that look like comments are not parsed at all.
+func (l *Language) lex(state *lexState, n int, line string) (lexedLine, bool) {
+	return l.mask(state, n, line), l != LanguageDefault || !isCommentLine(line)
+}
+
+// isCommentLine tells the lines that start with a comment marker of any of
+// the supported languages.
+func isCommentLine(line string) bool {
+	tstring := strings.TrimSpace(line)
+	switch {
+	case strings.HasPrefix(tstring, "//"):
+		fallthrough
+	case strings.HasPrefix(tstring, "--"):
+		fallthrough
+	case strings.HasPrefix(tstring, "#"):
+		fallthrough
+	case strings.HasPrefix(tstring, "/*"):
+		fallthrough
+	case strings.HasPrefix(tstring, "<!--"):
+		fallthrough
+	case strings.HasPrefix(tstring, "!*"):
+		fallthrough
+	case strings.HasPrefix(tstring, "{-"):
+		fallthrough
+	case strings.HasPrefix(tstring, "%"):
+		fallthrough
+	case strings.HasPrefix(tstring, "\"\"\""):
+		return true
+	default:
+	}
+	return false
+}
+
+// formHead returns the opener of a form along with its first two words, as
+// (defn name, which are enough to tell which form it is.
+func formHead(line string, open Bracket) string {
+	_, rest := splitAtCol(line, open.Col+1)
+	if end := strings.IndexAny(rest, "()[]{}\";"); end >= 0 {
+		rest = rest[:end]
+	}
+	words := strings.Fields(rest)
+	if len(words) > 2 {
+		words = words[:2]
+	}
+	return strings.TrimSpace(open.String() + strings.Join(words, " "))
+}
+
+func clamp(v, lo, hi int) int {
+	if v < lo {
+		return lo
+	}
+	if v > hi {
+		return hi
+	}
+	return v
+}
+
+// splitAtCol splits line before the 1-based rune column col.
+func splitAtCol(line string, col int) (string, string) {
+	n := 1
+	for i := range line {
+		if n >= col {
+			return line[:i], line[i:]
+		}
+		n++
+	}
+	return line, ""
+}
+
+// ApplyEdit changes the text of the document and parses it again from the
+// first edited line, reusing the results of the lines after the edit once
+// the parser reaches them in the same state as before.
+func (doc *Document) ApplyEdit(e Edit) {
+	first := clamp(e.StartLine, 1, len(doc.lines))
+	last := clamp(e.EndLine, first, len(doc.lines))
+	prefix, _ := splitAtCol(doc.lines[first-1], e.StartCol)
+	if e.EndLine > len(doc.lines) {
+		e.EndCol = len(doc.lines[last-1]) + 1
+	}
+	_, suffix := splitAtCol(doc.lines[last-1], e.EndCol)
+	inserted := SplitLines(prefix + e.Text + suffix)
+	delta := len(inserted) - (last - first + 1)
+	end := first + len(inserted)
+
+	if delta == 0 {
+		// Line numbers do not move: the lines after the edit keep their
+		// results until the new state differs from the recorded one.
+		copy(doc.lines[first-1:], inserted)
+		doc.parseFrom(first, func(n int, state lineState) bool {
+			return n >= end && state.equal(doc.states[n-1], last, 0)
+		})
+		return
+	}
+
+	oldStates, oldResults := doc.states, doc.results
+	lines := make([]string, 0, len(doc.lines)+delta)
+	lines = append(lines, doc.lines[:first-1]...)
+	lines = append(lines, inserted...)
+	doc.lines = append(lines, doc.lines[last:]...)
+	doc.states = append(make([]lineState, 0, len(doc.lines)+1), oldStates[:first]...)
+	doc.results = append(make([]lineResult, 0, len(doc.lines)), oldResults[:first-1]...)
+
+	converged := doc.parseFrom(first, func(n int, state lineState) bool {
+		return n >= end && state.equal(oldStates[n-1-delta], last, delta)
+	})
+	if converged == 0 {
+		return
+	}
+	for _, r := range oldResults[converged-1-delta:] {
+		doc.results = append(doc.results, r.shift(last, delta))
+	}
+	for _, s := range oldStates[converged-delta:] {
+		doc.states = append(doc.states, s.shift(last, delta))
+	}
+}
+
+// IgnoreFile reports whether the document holds a drbracket:ignore-file
+// directive.
+func (doc *Document) IgnoreFile() bool {
+	return doc.ignoreFile() != nil
+}
+
+func (doc *Document) ignoreFile() *Directive {
+	for _, r := range doc.results {
+		for i := range r.directives {
+			if r.directives[i].Name == DirectiveIgnoreFile {
+				return &r.directives[i]
+			}
+		}
+	}
+	return nil
+}
+
+// Diagnostics returns the problems found in the document, the same that
+// feeding every line to a BracketParser and finishing it would report.
+func (doc *Document) Diagnostics() []Diagnostic {
+	if doc.IgnoreFile() {
+		return nil
+	}
+	return doc.diagnostics()
+}
+
+func (doc *Document) diagnostics() []Diagnostic {
+	res := make([]Diagnostic, 0)
+	for _, r := range doc.results {
+		res = append(res, r.diagnostics...)
+	}
+	last := doc.states[len(doc.states)-1]
+	for i, b := range last.stack {
+		if i == 0 && doc.lang.NamedForms {
+			res = append(res, *unclosedFormError(b, formHead(doc.Line(b.Line), b)))
+			continue
+		}
+		if !doc.lang.implied(b) {
+			res = append(res, *unclosedError(b))
+		}
+	}
+	for _, b := range last.shared {
+		res = append(res, *unclosedError(b))
+	}
+	return append(res, last.lex.unterminated()...)
+}
+
+// UnusedSuppressions returns a warning for every directive that silences no
+// bracket problem: the lines it suppresses are balanced on their own or, for
+// drbracket:ignore-file, the whole file is.
+func (doc *Document) UnusedSuppressions() []Diagnostic {
+	unused := make([]Diagnostic, 0)
+	if d := doc.ignoreFile(); d != nil && len(doc.diagnostics()) == 0 {
+		unused = append(unused, unusedError(*d))
+	}
+	parsers := make(map[Directive]*BracketParser)
+	for _, r := range doc.results {
+		for _, d := range r.started {
+			parsers[d] = NewBracketParser()
+		}
+	}
+	for i, r := range doc.results {
+		if r.suppressedBy == nil {
+			continue
+		}
+		if p, ok := parsers[*r.suppressedBy]; ok {
+			doc.lang.parseCode(p, i+1, r.lexed)
+		}
+	}
+	for _, r := range doc.results {
+		for _, d := range r.started {
+			if len(parsers[d].Finish()) == 0 {
+				unused = append(unused, unusedError(d))
+			}
+		}
+	}
+	return unused
+}
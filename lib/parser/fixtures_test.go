@@ -0,0 +1,67 @@
+// Copyright (C) 2020 Fabio Del Vigna
+//
+// This file is part of drbracket.
+//
+// drbracket is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// drbracket is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with drbracket.  If not, see <http://www.gnu.org/licenses/>.
+
+package parser
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestFixtures(t *testing.T) {
+	tests := []struct {
+		file string
+		want []string
+	}{
+		{"hello_world.c", nil},
+		{"hello_world_broken.c", []string{"6:1 mismatched"}},
+		{"swap.cpp", nil},
+		{"swap_broken.cpp", []string{"5:1 unclosed"}},
+		{"unclosed_comment_broken.c", []string{"6:1 unclosed-comment"}},
+		{"unclosed_string_broken.py", []string{"4:9 unclosed-string"}},
+		{"continuation.c", nil},
+		{"raw_block.j2", nil},
+		{"php_blocks.php", nil},
+		{"raw_fstring.py", nil},
+		{"comments.sh", nil},
+		{"strings.hs", nil},
+		{"strings.adb", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.file, func(t *testing.T) {
+			path := filepath.Join("..", "..", "test", tt.file)
+			text, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			lang := LanguageForFile(path)
+			if lang == nil {
+				t.Fatalf("No language for %s", tt.file)
+			}
+			var got []string
+			for _, d := range NewDocument(lang, string(text)).Diagnostics() {
+				got = append(got, fmt.Sprintf("%d:%d %s", d.Line, d.Col, d.Kind))
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Diagnostics of %s: got %v, want %v", tt.file, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,138 @@
+// Copyright (C) 2020 Fabio Del Vigna
+//
+// This file is part of drbracket.
+//
+// drbracket is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// drbracket is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with drbracket.  If not, see <http://www.gnu.org/licenses/>.
+
+package parser
+
+import (
+	"strings"
+	"unicode/utf8"
+)
+
+// KeywordPair is a couple of words that open and close a block, as if and
+// fi in shell scripts.
+type KeywordPair struct {
+	Open  string
+	Close string
+	// Absorbs is a closing bracket that stands alone right inside the
+	// block, as the ) ending the patterns of shell case arms.
+	Absorbs string
+}
+
+// parseCode feeds a lexed line to p, recognising the keywords and tags of
+// the language along with brackets.
+func (l *Language) parseCode(p *BracketParser, lineNum int, line lexedLine) {
+	if line.lang != nil {
+		l = line.lang
+	}
+	code := line.code
+	if len(l.Keywords) == 0 && l.Markup == nil && len(line.tags) == 0 {
+		_ = p.ParseLine(lineNum, code)
+		return
+	}
+	p.absorbs = l.absorbs
+	p.implied = l.implied
+	p.isolated = l.isolated
+	if line.shared {
+		p.swap()
+	}
+	tags := line.tags
+	col := 1
+	for i := 0; i < len(code); {
+		for len(tags) > 0 && tags[0].col == col {
+			p.parseTag(lineNum, tags[0])
+			tags = tags[1:]
+		}
+		if word := l.keywordAt(code, i); word != "" {
+			for _, k := range l.Keywords {
+				switch word {
+				case k.Open:
+					p.Push(Bracket{Name: k.Open, Close: k.Close, Line: lineNum, Col: col})
+				case k.Close:
+					_ = p.closeBracket(Bracket{Name: k.Close, Line: lineNum, Col: col})
+				default:
+					continue
+				}
+				break
+			}
+			i += len(word)
+			col += len(word)
+			continue
+		}
+		c, size := utf8.DecodeRuneInString(code[i:])
+		_ = p.parseRune(c, lineNum, col)
+		i += size
+		col++
+	}
+	if p.swapped {
+		p.swap()
+	}
+}
+
+// keywordAt returns the keyword of l that starts at byte i of code, if any.
+// Keywords must be whole words, and in command position when the language
+// says so.
+func (l *Language) keywordAt(code string, i int) string {
+	if i > 0 && isWordByte(code[i-1]) {
+		return ""
+	}
+	for _, k := range l.Keywords {
+		for _, word := range []string{k.Open, k.Close} {
+			end := i + len(word)
+			if !strings.HasPrefix(code[i:], word) || (end < len(code) && isWordByte(code[end])) {
+				continue
+			}
+			if l.CommandWords != nil && !l.commandStart(code[:i]) {
+				return ""
+			}
+			return word
+		}
+	}
+	return ""
+}
+
+// commandStart tells whether a command starts right after before, the
+// code that precedes a word on its line.
+func (l *Language) commandStart(before string) bool {
+	before = strings.TrimRight(before, " \t")
+	if before == "" || strings.ContainsAny(before[len(before)-1:], ";&|(){}!`") {
+		return true
+	}
+	start := strings.LastIndexFunc(before, func(r rune) bool { return r > 0x7f || !isWordByte(byte(r)) })
+	prev := before[start+1:]
+	for _, w := range l.CommandWords {
+		if prev == w {
+			return l.commandStart(before[:start+1])
+		}
+	}
+	return false
+}
+
+func (l *Language) absorbs(open, c Bracket) bool {
+	for _, k := range l.Keywords {
+		if open.Name == k.Open && k.Absorbs != "" && k.Absorbs == c.String() {
+			return true
+		}
+	}
+	return false
+}
+
+// isWordByte tells the bytes that may be part of a keyword or of the words
+// around one, which also covers paths and options such as /bin/done or
+// --if.
+func isWordByte(b byte) bool {
+	return isIdentByte(b) || b == '-' || b == '.' || b == '/' || b == '$' || b == '='
+}
@@ -0,0 +1,632 @@
+// Copyright (C) 2020 Fabio Del Vigna
+//
+// This file is part of drbracket.
+//
+// drbracket is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// drbracket is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with drbracket.  If not, see <http://www.gnu.org/licenses/>.
+
+package parser
+
+import (
+	"path/filepath"
+	"strings"
+)
+
+type Language struct {
+	Name          string
+	Extensions    []string
+	LineComments  []string
+	BlockComments [][2]string
+	Strings       []StringSyntax
+	Keywords      []KeywordPair
+	// CommandWords are set when keywords only count at the start of a
+	// command, which may follow any of them, as in shell scripts.
+	CommandWords []string
+	// CodeEscapes is set when a backslash in code escapes the next
+	// character, and Heredocs when <<WORD starts a here-document.
+	// WordComments is set when line comments only start at the beginning
+	// of a word, as in shells, where ${#x} holds none.
+	CodeEscapes  bool
+	Heredocs     bool
+	WordComments bool
+	// NestedComments is set when block comments nest, and NamedForms when
+	// an unclosed top-level form is reported along with its head, as in
+	// Lisp.
+	NestedComments bool
+	NamedForms     bool
+	// Markup is set for languages with tags that must be balanced, and
+	// Fences for Markdown, of which only the code blocks are checked.
+	Markup *Markup
+	Fences bool
+	// TeX is set when environments, \left and \right and math delimiters
+	// must be balanced, as in LaTeX.
+	TeX      bool
+	Template *Template
+	// Expansions are the holes that open in code as well as in literals,
+	// as ${x} in shells.
+	Expansions []Interpolation
+}
+
+// Single-line double and single quoted literals with backslash escapes, as
+// found in C and many of its descendants.
+var (
+	doubleQuoted = StringSyntax{Open: `"`, Escapes: true}
+	singleQuoted = StringSyntax{Open: "'", Escapes: true}
+)
+
+var (
+	dollarBraces = []Interpolation{{Open: "${", Close: "}"}}
+	braces       = []Interpolation{{Open: "{", Close: "}"}}
+	swiftHoles   = []Interpolation{{Open: "\\(", Close: ")"}}
+	doubleBraces = []string{"{{", "}}"}
+)
+
+var (
+	jinjaComments      = [][2]string{{"{#", "#}"}}
+	handlebarsComments = [][2]string{{"{{!--", "--}}"}, {"{{!", "}}"}}
+)
+
+// shellWord is the word of a shell parameter expansion, as in ${x:-word},
+// which ends at the first } and in which substitutions are expanded.
+var shellWord = &StringSyntax{Close: "}", Escapes: true}
+
+var shellHoles = []Interpolation{{Open: "$(", Close: ")"}, {Open: "${", Close: "}", Word: shellWord}}
+
+func init() {
+	shellWord.Interpolations = shellHoles
+}
+
+// Comments, character data and the declarations and processing
+// instructions of markup, whose contents are not checked.
+var markupComments = [][2]string{{"<!--", "-->"}, {"<![CDATA[", "]]>"}, {"<!", ">"}, {"<?", "?>"}}
+
+// caseVariants returns every way of writing the prefixes in upper and lower
+// case, as Python accepts them.
+func caseVariants(prefixes ...string) []string {
+	res := make([]string, 0)
+	for _, p := range prefixes {
+		variants := []string{""}
+		for _, c := range p {
+			next := make([]string, 0, 2*len(variants))
+			for _, v := range variants {
+				next = append(next, v+strings.ToLower(string(c)), v+strings.ToUpper(string(c)))
+			}
+			variants = next
+		}
+		res = append(res, variants...)
+	}
+	return res
+}
+
+// phpCode is the language of the code in PHP pages.
+var phpCode = &Language{
+	Name:          "php",
+	LineComments:  []string{"//", "#"},
+	BlockComments: [][2]string{{"/*", "*/"}},
+	Strings: []StringSyntax{
+		{Open: `"`, Escapes: true, Multiline: true, Interpolations: []Interpolation{{Open: "{$", Close: "}"}}},
+		{Open: "'", Escapes: true, Multiline: true},
+	},
+}
+
+// htmlMarkup returns the markup of HTML, in which scripts and styles are
+// regions of their own, along with the given regions.
+func htmlMarkup(regions ...Region) *Markup {
+	return &Markup{
+		IgnoreCase: true,
+		Void: []string{"area", "base", "br", "col", "embed", "hr", "img", "input",
+			"link", "meta", "param", "source", "track", "wbr"},
+		OptionalEnd: []string{"html", "head", "body", "p", "li", "dt", "dd", "option",
+			"optgroup", "colgroup", "thead", "tbody", "tfoot", "tr", "td", "th", "rp", "rt"},
+		RawText: []string{"textarea", "title"},
+		Regions: append([]Region{
+			{Element: "script", Language: LanguageJavaScript},
+			{Element: "style", Language: LanguageCSS},
+		}, regions...),
+	}
+}
+
+var (
+	LanguageC = &Language{
+		Name:          "c",
+		Extensions:    []string{"c", "cbp", "cls"},
+		LineComments:  []string{"//"},
+		BlockComments: [][2]string{{"/*", "*/"}},
+		Strings:       []StringSyntax{doubleQuoted, singleQuoted},
+	}
+	LanguageJavaScript = &Language{
+		Name:          "javascript",
+		Extensions:    []string{"js", "mjs", "cjs", "ts"},
+		LineComments:  []string{"//"},
+		BlockComments: [][2]string{{"/*", "*/"}},
+		Strings: []StringSyntax{
+			{Open: "`", Escapes: true, Multiline: true, Interpolations: dollarBraces},
+			doubleQuoted,
+			singleQuoted,
+		},
+	}
+	LanguageCpp = &Language{
+		Name:          "cpp",
+		Extensions:    []string{"cc", "cpp", "cxx", "hpp", "hxx"},
+		LineComments:  []string{"//"},
+		BlockComments: [][2]string{{"/*", "*/"}},
+		Strings: []StringSyntax{
+			{Prefixes: []string{"R", "u8R", "uR", "UR", "LR"}, Open: `"`, Multiline: true, Delimiter: DelimiterParen},
+			doubleQuoted,
+			singleQuoted,
+		},
+	}
+	LanguageCSharp = &Language{
+		Name:          "csharp",
+		Extensions:    []string{"cs"},
+		LineComments:  []string{"//"},
+		BlockComments: [][2]string{{"/*", "*/"}},
+		Strings: []StringSyntax{
+			{Prefixes: []string{"$"}, Open: `"""`, Multiline: true, Delimiter: DelimiterRepeat, Interpolations: braces, Verbatim: doubleBraces},
+			{Open: `"""`, Multiline: true, Delimiter: DelimiterRepeat},
+			{Prefixes: []string{"$@", "@$"}, Open: `"`, Doubled: true, Multiline: true, Interpolations: braces, Verbatim: doubleBraces},
+			{Prefixes: []string{"@"}, Open: `"`, Doubled: true, Multiline: true},
+			{Prefixes: []string{"$"}, Open: `"`, Escapes: true, Interpolations: braces, Verbatim: doubleBraces},
+			doubleQuoted,
+			singleQuoted,
+		},
+	}
+	LanguageJava = &Language{
+		Name:          "java",
+		Extensions:    []string{"java"},
+		LineComments:  []string{"//"},
+		BlockComments: [][2]string{{"/*", "*/"}},
+		Strings: []StringSyntax{
+			{Open: `"""`, Escapes: true, Multiline: true},
+			doubleQuoted,
+			singleQuoted,
+		},
+	}
+	LanguageGo = &Language{
+		Name:          "go",
+		Extensions:    []string{"go"},
+		LineComments:  []string{"//"},
+		BlockComments: [][2]string{{"/*", "*/"}},
+		Strings: []StringSyntax{
+			{Open: "`", Multiline: true},
+			doubleQuoted,
+			singleQuoted,
+		},
+	}
+	LanguageScala = &Language{
+		Name:          "scala",
+		Extensions:    []string{"scala"},
+		LineComments:  []string{"//"},
+		BlockComments: [][2]string{{"/*", "*/"}},
+		Strings: []StringSyntax{
+			{Prefixes: []string{"s", "f", "raw"}, Open: `"""`, Multiline: true, Interpolations: dollarBraces, Verbatim: []string{"$$"}},
+			{Prefixes: []string{"s", "f", "raw"}, Open: `"`, Escapes: true, Interpolations: dollarBraces, Verbatim: []string{"$$"}},
+			{Open: `"""`, Multiline: true},
+			doubleQuoted,
+		},
+	}
+	LanguageKotlin = &Language{
+		Name:          "kotlin",
+		Extensions:    []string{"kt", "kts"},
+		LineComments:  []string{"//"},
+		BlockComments: [][2]string{{"/*", "*/"}},
+		Strings: []StringSyntax{
+			{Open: `"""`, Multiline: true, Interpolations: dollarBraces},
+			{Open: `"`, Escapes: true, Interpolations: dollarBraces},
+			singleQuoted,
+		},
+	}
+	LanguageSwift = &Language{
+		Name:          "swift",
+		Extensions:    []string{"swift"},
+		LineComments:  []string{"//"},
+		BlockComments: [][2]string{{"/*", "*/"}},
+		Strings: []StringSyntax{
+			{Open: `"""`, Multiline: true, Delimiter: DelimiterHashes},
+			{Open: `"`, Delimiter: DelimiterHashes},
+			{Open: `"""`, Escapes: true, Multiline: true, Interpolations: swiftHoles},
+			{Open: `"`, Escapes: true, Interpolations: swiftHoles},
+		},
+	}
+	LanguageRust = &Language{
+		Name:          "rust",
+		Extensions:    []string{"rs"},
+		LineComments:  []string{"//"},
+		BlockComments: [][2]string{{"/*", "*/"}},
+		Strings: []StringSyntax{
+			{Prefixes: []string{"r", "br", "cr"}, Open: `"`, Multiline: true, Delimiter: DelimiterHashes},
+			{Open: `"`, Escapes: true, Multiline: true},
+			{Open: "'", Escapes: true, Char: true},
+		},
+	}
+	LanguageD = &Language{
+		Name:          "d",
+		Extensions:    []string{"d"},
+		LineComments:  []string{"//"},
+		BlockComments: [][2]string{{"/*", "*/"}, {"/+", "+/"}},
+		Strings: []StringSyntax{
+			{Prefixes: []string{"r"}, Open: `"`, Multiline: true},
+			{Open: "`", Multiline: true},
+			{Open: `"`, Escapes: true, Multiline: true},
+			singleQuoted,
+		},
+	}
+	LanguageObjC = &Language{
+		Name:          "objc",
+		Extensions:    []string{"m"},
+		LineComments:  []string{"//"},
+		BlockComments: [][2]string{{"/*", "*/"}},
+		Strings:       []StringSyntax{doubleQuoted, singleQuoted},
+	}
+	LanguageScilab = &Language{
+		Name:         "scilab",
+		Extensions:   []string{"sci"},
+		LineComments: []string{"//"},
+		Strings: []StringSyntax{
+			{Open: `"`, Doubled: true},
+			{Open: "'", Doubled: true, NotAfterValue: true},
+		},
+	}
+	fStrings       = caseVariants("f")
+	rawFStrings    = caseVariants("rf", "fr")
+	LanguagePython = &Language{
+		Name:         "python",
+		Extensions:   []string{"py"},
+		LineComments: []string{"#"},
+		Strings: []StringSyntax{
+			{Prefixes: fStrings, Open: `"""`, Escapes: true, Multiline: true, Interpolations: braces, Verbatim: doubleBraces},
+			{Prefixes: fStrings, Open: "'''", Escapes: true, Multiline: true, Interpolations: braces, Verbatim: doubleBraces},
+			{Prefixes: fStrings, Open: `"`, Escapes: true, Interpolations: braces, Verbatim: doubleBraces},
+			{Prefixes: fStrings, Open: "'", Escapes: true, Interpolations: braces, Verbatim: doubleBraces},
+			{Prefixes: rawFStrings, Open: `"""`, Multiline: true, Interpolations: braces, Verbatim: doubleBraces},
+			{Prefixes: rawFStrings, Open: "'''", Multiline: true, Interpolations: braces, Verbatim: doubleBraces},
+			{Prefixes: rawFStrings, Open: `"`, Interpolations: braces, Verbatim: doubleBraces},
+			{Prefixes: rawFStrings, Open: "'", Interpolations: braces, Verbatim: doubleBraces},
+			{Open: `"""`, Escapes: true, Multiline: true},
+			{Open: "'''", Escapes: true, Multiline: true},
+			doubleQuoted,
+			singleQuoted,
+		},
+	}
+	LanguageRuby = &Language{
+		Name:         "ruby",
+		Extensions:   []string{"rb"},
+		LineComments: []string{"#"},
+		Strings: []StringSyntax{
+			{Open: `"`, Escapes: true, Multiline: true, Interpolations: []Interpolation{{Open: "#{", Close: "}"}}},
+			{Open: "`", Escapes: true, Multiline: true, Interpolations: []Interpolation{{Open: "#{", Close: "}"}}},
+			{Open: "'", Escapes: true, Multiline: true},
+		},
+	}
+	LanguageShell = &Language{
+		Name:         "shell",
+		Extensions:   []string{"sh", "bash"},
+		LineComments: []string{"#"},
+		Strings: []StringSyntax{
+			{Open: `"`, Escapes: true, Multiline: true, Interpolations: shellHoles},
+			{Open: "'", Multiline: true},
+		},
+		Expansions: shellHoles[1:],
+		Keywords: []KeywordPair{
+			{Open: "if", Close: "fi"},
+			{Open: "case", Close: "esac", Absorbs: ")"},
+			{Open: "do", Close: "done"},
+		},
+		CommandWords: []string{"then", "else", "elif", "do", "if", "while", "until", "time", "!"},
+		CodeEscapes:  true,
+		Heredocs:     true,
+		WordComments: true,
+	}
+	LanguageHash = &Language{
+		Name:         "hash",
+		Extensions:   []string{"r", "conf"},
+		LineComments: []string{"#"},
+		Strings:      []StringSyntax{doubleQuoted, singleQuoted},
+	}
+	LanguageAda = &Language{
+		Name:         "ada",
+		Extensions:   []string{"ada", "adb", "2.ada"},
+		LineComments: []string{"--"},
+		Strings: []StringSyntax{
+			{Open: `"`, Doubled: true},
+			{Open: "'", Char: true, NotAfterValue: true},
+		},
+	}
+	LanguageHaskell = &Language{
+		Name:          "haskell",
+		Extensions:    []string{"hs"},
+		LineComments:  []string{"--"},
+		BlockComments: [][2]string{{"{-", "-}"}},
+		Strings: []StringSyntax{
+			doubleQuoted,
+			{Open: "'", Escapes: true, Char: true, NotAfterValue: true},
+		},
+	}
+	// LanguageLisp takes \( and #\( for the character literals of Clojure
+	// and Common Lisp. Clojure's #_ discards a form that must still be
+	// balanced, so like #( and #{ it needs nothing special, even at the
+	// start of a line: only ; and #| start comments.
+	LanguageLisp = &Language{
+		Name:           "lisp",
+		Extensions:     []string{"clj", "cljs", "cljc", "edn", "lisp", "scm"},
+		LineComments:   []string{";"},
+		BlockComments:  [][2]string{{"#|", "|#"}},
+		Strings:        []StringSyntax{{Open: `"`, Escapes: true, Multiline: true}},
+		CodeEscapes:    true,
+		NestedComments: true,
+		NamedForms:     true,
+	}
+	LanguageCSS = &Language{
+		Name:          "css",
+		Extensions:    []string{"css"},
+		BlockComments: [][2]string{{"/*", "*/"}},
+		Strings:       []StringSyntax{doubleQuoted, singleQuoted},
+	}
+	LanguageHTML = &Language{
+		Name:          "html",
+		Extensions:    []string{"html", "htm"},
+		BlockComments: markupComments,
+		Markup:        htmlMarkup(),
+	}
+	// Single-file components keep their template, script and style in
+	// one file, which is read as HTML.
+	LanguageVue = &Language{
+		Name:          "vue",
+		Extensions:    []string{"vue", "svelte"},
+		BlockComments: markupComments,
+		Markup:        htmlMarkup(),
+	}
+	// LanguagePHP reads pages as HTML, in which PHP code starts at <?php or
+	// <?= and goes on up to ?>.
+	LanguagePHP = &Language{
+		Name:          "php",
+		Extensions:    []string{"php", "phtml"},
+		BlockComments: markupComments,
+		Markup: htmlMarkup(
+			Region{Open: "<?php", Close: "?>", Language: phpCode},
+			Region{Open: "<?=", Close: "?>", Language: phpCode},
+		),
+	}
+	LanguageXML = &Language{
+		Name:          "xml",
+		Extensions:    []string{"xml", "svg", "xsd", "xsl", "xslt", "xhtml"},
+		BlockComments: markupComments,
+		Markup:        &Markup{},
+	}
+	// LanguageJSX finds elements where an expression may start, so that
+	// comparisons and type arguments are not taken for tags.
+	LanguageJSX = &Language{
+		Name:          "jsx",
+		Extensions:    []string{"jsx", "tsx"},
+		LineComments:  []string{"//"},
+		BlockComments: [][2]string{{"/*", "*/"}},
+		Strings:       LanguageJavaScript.Strings,
+		Markup:        &Markup{Embedded: true},
+	}
+	LanguageLaTeX = &Language{
+		Name:         "latex",
+		Extensions:   []string{"tex", "sty", "ltx"},
+		LineComments: []string{"%"},
+		CodeEscapes:  true,
+		TeX:          true,
+	}
+	// Go templates take their comments inside actions, as in {{/* a */}}.
+	LanguageGoTemplate = &Language{
+		Name:          "gotemplate",
+		Extensions:    []string{"tmpl", "gotmpl", "gohtml"},
+		BlockComments: [][2]string{{"/*", "*/"}},
+		Strings:       LanguageGo.Strings,
+		Template: &Template{
+			Actions: [][2]string{{"{{", "}}"}},
+			Blocks: []KeywordPair{
+				{Open: "if", Close: "end"},
+				{Open: "range", Close: "end"},
+				{Open: "with", Close: "end"},
+				{Open: "define", Close: "end"},
+				{Open: "block", Close: "end"},
+			},
+		},
+	}
+	LanguageJinja = &Language{
+		Name:          "jinja",
+		Extensions:    []string{"j2", "jinja", "jinja2"},
+		BlockComments: jinjaComments,
+		Strings:       []StringSyntax{doubleQuoted, singleQuoted},
+		Template: &Template{
+			Actions:  [][2]string{{"{%", "%}"}, {"{{", "}}"}},
+			Comments: jinjaComments,
+			Blocks: []KeywordPair{
+				{Open: "for", Close: "endfor"},
+				{Open: "if", Close: "endif"},
+				{Open: "block", Close: "endblock"},
+				{Open: "macro", Close: "endmacro"},
+				{Open: "call", Close: "endcall"},
+				{Open: "filter", Close: "endfilter"},
+				{Open: "with", Close: "endwith"},
+				{Open: "autoescape", Close: "endautoescape"},
+				{Open: "trans", Close: "endtrans"},
+				{Open: "raw", Close: "endraw"},
+			},
+			Verbatim: []string{"raw"},
+		},
+	}
+	LanguageHandlebars = &Language{
+		Name:          "handlebars",
+		Extensions:    []string{"hbs", "handlebars", "mustache"},
+		BlockComments: handlebarsComments,
+		Strings:       []StringSyntax{doubleQuoted, singleQuoted},
+		Template: &Template{
+			Actions:  [][2]string{{"{{{", "}}}"}, {"{{", "}}"}},
+			Comments: handlebarsComments,
+			Sections: true,
+		},
+	}
+	LanguageMarkdown = &Language{
+		Name:          "markdown",
+		Extensions:    []string{"md", "markdown"},
+		BlockComments: [][2]string{{"<!--", "-->"}},
+		Fences:        true,
+	}
+	LanguageFortran = &Language{
+		Name:         "fortran",
+		Extensions:   []string{"for", "ftn", "f90"},
+		LineComments: []string{"!"},
+		Strings: []StringSyntax{
+			{Open: `"`, Doubled: true},
+			{Open: "'", Doubled: true},
+		},
+	}
+	LanguageBasic = &Language{
+		Name:         "basic",
+		Extensions:   []string{"bas"},
+		LineComments: []string{"'", "REM "},
+		Strings:      []StringSyntax{{Open: `"`, Doubled: true}},
+	}
+	// LanguageDefault is used for files of unknown type and accepts the
+	// comment markers of all the languages above.
+	LanguageDefault = &Language{
+		Name:          "default",
+		LineComments:  []string{"//", "#", "--", ";", "%", "!"},
+		BlockComments: [][2]string{{"/*", "*/"}, {"<!--", "-->"}, {"{-", "-}"}},
+	}
+)
+
+var languages = []*Language{
+	LanguageC,
+	LanguageJavaScript,
+	LanguageCpp,
+	LanguageCSharp,
+	LanguageJava,
+	LanguageGo,
+	LanguageScala,
+	LanguageKotlin,
+	LanguageSwift,
+	LanguageRust,
+	LanguageD,
+	LanguagePHP,
+	LanguageObjC,
+	LanguageScilab,
+	LanguagePython,
+	LanguageRuby,
+	LanguageShell,
+	LanguageHash,
+	LanguageAda,
+	LanguageHaskell,
+	LanguageLisp,
+	LanguageCSS,
+	LanguageHTML,
+	LanguageVue,
+	LanguageXML,
+	LanguageJSX,
+	LanguageLaTeX,
+	LanguageGoTemplate,
+	LanguageJinja,
+	LanguageHandlebars,
+	LanguageMarkdown,
+	LanguageFortran,
+	LanguageBasic,
+}
+
+func LanguageForFile(filename string) *Language {
+	base := strings.ToLower(filepath.Base(filename))
+	for _, lang := range languages {
+		for _, ext := range lang.Extensions {
+			if strings.HasSuffix(base, "."+ext) {
+				return lang
+			}
+		}
+	}
+	return LanguageDefault
+}
+
+// languageAliases are the names that code blocks go by, besides the names
+// and extensions of the languages.
+var languageAliases = map[string]*Language{
+	"c++":          LanguageCpp,
+	"c#":           LanguageCSharp,
+	"typescript":   LanguageJavaScript,
+	"objectivec":   LanguageObjC,
+	"objective-c":  LanguageObjC,
+	"python3":      LanguagePython,
+	"bash":         LanguageShell,
+	"zsh":          LanguageShell,
+	"shell-script": LanguageShell,
+	"clojure":      LanguageLisp,
+	"scheme":       LanguageLisp,
+	"elisp":        LanguageLisp,
+	"svelte":       LanguageVue,
+	"svg":          LanguageXML,
+}
+
+// LanguageForName returns the language called name, as in the info string
+// of a code block, or nil if there is none.
+func LanguageForName(name string) *Language {
+	name = strings.ToLower(name)
+	if name == "" {
+		return nil
+	}
+	if lang, ok := languageAliases[name]; ok {
+		return lang
+	}
+	for _, lang := range languages {
+		if lang.Name == name {
+			return lang
+		}
+	}
+	for _, lang := range languages {
+		for _, ext := range lang.Extensions {
+			if ext == name {
+				return lang
+			}
+		}
+	}
+	return nil
+}
+
+// comments looks for the comments that start on line, for the languages
+// whose lines are not lexed. A block comment that is not closed on the same
+// line extends to its end.
+func (l *Language) comments(line string) []comment {
+	comments := make([]comment, 0)
+	for i := 0; i < len(line); {
+		if marker := hasAnyPrefix(line[i:], l.LineComments); marker != "" {
+			return append(comments, comment{text: line[i+len(marker):], col: runeCol(line, i)})
+		}
+		block := l.blockComment(line[i:])
+		if block[0] == "" {
+			i++
+			continue
+		}
+		body := line[i+len(block[0]):]
+		end := strings.Index(body, block[1])
+		if end < 0 {
+			return append(comments, comment{text: body, col: runeCol(line, i)})
+		}
+		comments = append(comments, comment{text: body[:end], col: runeCol(line, i)})
+		i += len(block[0]) + end + len(block[1])
+	}
+	return comments
+}
+
+func hasAnyPrefix(s string, prefixes []string) string {
+	for _, p := range prefixes {
+		if strings.HasPrefix(s, p) {
+			return p
+		}
+	}
+	return ""
+}
+
+func runeCol(line string, offset int) int {
+	return len([]rune(line[:offset])) + 1
+}
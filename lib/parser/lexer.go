@@ -0,0 +1,695 @@
+// Copyright (C) 2020 Fabio Del Vigna
+//
+// This file is part of drbracket.
+//
+// drbracket is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// drbracket is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with drbracket.  If not, see <http://www.gnu.org/licenses/>.
+
+package parser
+
+import (
+	"strings"
+	"unicode/utf8"
+)
+
+// Delimiter tells how the terminator of a string literal is built from its
+// opening.
+type Delimiter int
+
+const (
+	// DelimiterFixed literals end with Close.
+	DelimiterFixed Delimiter = iota
+	// DelimiterParen literals are C++ raw strings, R"d(...)d", whose
+	// terminator repeats the delimiter d.
+	DelimiterParen
+	// DelimiterHashes literals are wrapped in as many # as they open with,
+	// as Rust r#"..."# and Swift #"..."#. Without a prefix at least one # is
+	// needed.
+	DelimiterHashes
+	// DelimiterRepeat literals open with Open or more of its characters and
+	// end with as many, as C# raw strings.
+	DelimiterRepeat
+)
+
+// StringSyntax describes a kind of string literal of a language.
+type StringSyntax struct {
+	// Prefixes are the alternative identifiers that must precede Open.
+	Prefixes []string
+	Open     string
+	// Close defaults to Open.
+	Close string
+	// Escapes is set when a backslash escapes the next character.
+	Escapes bool
+	// Doubled is set when the terminator written twice stands for itself.
+	Doubled   bool
+	Multiline bool
+	Delimiter Delimiter
+	// Char is set for character literals, which hold a single character
+	// or escape, so that a quote starting anything else, as the lifetime
+	// 'a in Rust, opens none.
+	Char bool
+	// NotAfterValue is set when Open right after a name, a number or a
+	// closing bracket is an operator rather than a literal, as the
+	// transpose ' of Scilab or the attribute tick X'First of Ada.
+	NotAfterValue bool
+	// Interpolations are the holes of code the literal may hold, and
+	// Verbatim the sequences that look like their start but stand for
+	// themselves, as {{ in Python f-strings.
+	Interpolations []Interpolation
+	Verbatim       []string
+}
+
+// Interpolation is a hole of code in a string literal, as ${x} in
+// JavaScript template literals. Holes with a Word hold no code but a word
+// lexed as a literal of that syntax, as ${x:-word} in shells, in which only
+// the holes it opens again are checked.
+type Interpolation struct {
+	Open  string
+	Close string
+	Word  *StringSyntax
+}
+
+// lexFrame is a string literal or block comment being skipped, or an
+// interpolation hole of a literal, in which code is parsed again. Markup
+// and templates add frames of their own. Frames are compared as values to
+// tell whether a line ends in the same state as before an edit.
+type lexFrame struct {
+	// close ends the frame. Literals, comments and holes keep the text that
+	// opened them and where, to report them if they are never closed.
+	close     string
+	open      string
+	line      int
+	col       int
+	multiline bool
+
+	// Literals and holes. Holes count the brackets open in them, so that
+	// only their own closer ends them, and word holes carry the syntax of
+	// their word. Nested comments count in depth the comments opened inside
+	// them, and comment frames have their text collected for the directives
+	// it may hold.
+	escapes bool
+	doubled bool
+	syntax  *StringSyntax
+	hole    bool
+	depth   int
+	nested  bool
+	comment bool
+
+	// Here-documents end with a line holding just their close word, after
+	// leading tabs with tabs set.
+	heredoc bool
+	tabs    bool
+
+	// Markup frames are the inside of a tag, the children of an element
+	// embedded in code, raw text elements, Markdown fences and the regions
+	// of a markup file written in another language.
+	tag      bool
+	end      bool
+	void     bool
+	children bool
+	raw      bool
+	name     string
+	region   *Language
+	fence    bool
+
+	// Template actions keep the block keyword they open, if any, in name
+	// and word, along with its position, and verbatim blocks the keyword
+	// that ends them in word.
+	action bool
+	word   string
+}
+
+// lexState carries the literals and comments left open at the end of a
+// line over to the next one.
+type lexState struct {
+	frames []lexFrame
+}
+
+func (s lexState) equal(o lexState, line, delta int) bool {
+	if len(s.frames) != len(o.frames) {
+		return false
+	}
+	for i, f := range s.frames {
+		if f != o.frames[i].shift(line, delta) {
+			return false
+		}
+	}
+	return true
+}
+
+func (s lexState) shift(line, delta int) lexState {
+	if len(s.frames) == 0 {
+		return s
+	}
+	res := lexState{frames: make([]lexFrame, len(s.frames))}
+	for i, f := range s.frames {
+		res.frames[i] = f.shift(line, delta)
+	}
+	return res
+}
+
+func (f lexFrame) shift(line, delta int) lexFrame {
+	if f.line > line {
+		f.line += delta
+	}
+	return f
+}
+
+func (s lexState) clone() lexState {
+	if len(s.frames) == 0 {
+		return lexState{}
+	}
+	return lexState{frames: append([]lexFrame(nil), s.frames...)}
+}
+
+func (s lexState) inCode() bool {
+	return len(s.frames) == 0
+}
+
+// unterminated reports the interpolation holes, comments and literals left
+// open at the end of the text.
+func (s lexState) unterminated() []Diagnostic {
+	res := make([]Diagnostic, 0)
+	for _, f := range s.frames {
+		switch {
+		case f.hole:
+			res = append(res, *unterminatedError(f))
+		case f.comment:
+			res = append(res, *unclosedLiteralError(DiagnosticUnclosedComment, f))
+		case f.syntax != nil:
+			res = append(res, *unclosedLiteralError(DiagnosticUnclosedString, f))
+		}
+	}
+	return res
+}
+
+// lexedLine is a line as the parser sees it: its code, with comments and
+// literals blanked out so that columns are kept, the tags found on it, the
+// text of the comments blanked out and the interpolations that single-line
+// literals left open.
+type lexedLine struct {
+	code        string
+	tags        []tagToken
+	comments    []comment
+	diagnostics []Diagnostic
+	// lang is the language of the code when it is not the one of the
+	// file, as in the code blocks of Markdown.
+	lang *Language
+	// shared is set when the line starts in a region delimited by its Open
+	// and Close, as the PHP blocks of a page.
+	shared bool
+}
+
+// comment is the text of a comment on a line, without its markers. Col is
+// where the comment starts and end the column after its text.
+type comment struct {
+	text string
+	col  int
+	end  int
+}
+
+type lexer struct {
+	lang    *Language
+	state   *lexState
+	lineNum int
+	line    string
+	col     int
+	sb      strings.Builder
+	masked  bool
+	res     lexedLine
+}
+
+func (lx *lexer) blank(s string) {
+	lx.masked = true
+	for range s {
+		lx.sb.WriteByte(' ')
+		lx.col++
+	}
+}
+
+func (lx *lexer) keep(s string) {
+	lx.sb.WriteString(s)
+	lx.col += utf8.RuneCountInString(s)
+}
+
+// comment adds text, which starts at column col, to the comment it goes on
+// from or else to a new one.
+func (lx *lexer) comment(text string, col int) {
+	end := col + utf8.RuneCountInString(text)
+	if n := len(lx.res.comments); n > 0 && lx.res.comments[n-1].end == col {
+		lx.res.comments[n-1].text += text
+		lx.res.comments[n-1].end = end
+		return
+	}
+	lx.res.comments = append(lx.res.comments, comment{text: text, col: col, end: end})
+}
+
+// openComment pushes the block comment with the given markers that opens at
+// the start of rest.
+func (lx *lexer) openComment(rest string, block [2]string, nested bool) int {
+	lx.push(lexFrame{close: block[1], multiline: true, open: block[0], nested: nested, comment: true, line: lx.lineNum, col: lx.col})
+	col := lx.col
+	lx.blank(rest[:len(block[0])])
+	lx.res.comments = append(lx.res.comments, comment{col: col, end: lx.col})
+	return len(block[0])
+}
+
+func (lx *lexer) push(f lexFrame) {
+	lx.state.frames = append(lx.state.frames, f)
+}
+
+func (lx *lexer) pop() {
+	lx.state.frames = lx.state.frames[:len(lx.state.frames)-1]
+}
+
+func (lx *lexer) top() *lexFrame {
+	if n := len(lx.state.frames); n > 0 {
+		return &lx.state.frames[n-1]
+	}
+	return nil
+}
+
+// hole opens an interpolation hole with the opener at the start of rest.
+func (lx *lexer) hole(open, close string, multiline bool) {
+	lx.push(interpolation(Interpolation{Open: open, Close: close}, multiline, lx.lineNum, lx.col))
+}
+
+// interpolation returns the frame of the hole in opened at lineNum and col.
+func interpolation(in Interpolation, multiline bool, lineNum, col int) lexFrame {
+	f := lexFrame{
+		close:     in.Close,
+		multiline: multiline,
+		hole:      true,
+		open:      in.Open,
+		line:      lineNum,
+		col:       col,
+	}
+	if in.Word != nil {
+		f.syntax, f.escapes = in.Word, in.Word.Escapes
+	}
+	return f
+}
+
+// mask lexes line, carrying over state the literals and comments that go on
+// past its end. LanguageDefault mixes the comment markers of many languages,
+// some of which are operators elsewhere, so nothing is blanked for it and
+// its comments are only looked for.
+func (l *Language) mask(state *lexState, lineNum int, line string) lexedLine {
+	if l == LanguageDefault {
+		return lexedLine{code: line, comments: l.comments(line)}
+	}
+	if l.Fences {
+		return l.maskFenced(state, lineNum, line)
+	}
+	if n := len(state.frames); n > 0 && state.frames[n-1].heredoc {
+		f := state.frames[n-1]
+		body := line
+		if f.tabs {
+			body = strings.TrimLeft(body, "\t")
+		}
+		if body == f.close {
+			state.frames = state.frames[:n-1]
+		}
+		return lexedLine{code: strings.Repeat(" ", utf8.RuneCountInString(line))}
+	}
+	lx := &lexer{lang: l, state: state, lineNum: lineNum, line: line, col: 1}
+	lx.res.shared = state.shared()
+	lx.sb.Grow(len(line))
+	var heredocs []lexFrame
+	for i := 0; i < len(line); {
+		rest := line[i:]
+		top := lx.top()
+		// Embedded regions are lexed with the rules of their own language.
+		code := l
+		region := lx.region()
+		if region != nil {
+			code = region.region
+		}
+		switch {
+		case top != nil && top.tag:
+			i += lx.tag(rest)
+			continue
+		case top != nil && top.children, top == nil && l.Markup != nil && !l.Markup.Embedded:
+			i += lx.text(rest)
+			continue
+		case top == nil && l.Template != nil:
+			i += lx.templateText(rest)
+			continue
+		case top != nil && top.region != nil:
+			if size, ok := lx.leaveRegion(rest); ok {
+				i += size
+				continue
+			}
+		case top != nil && top.action:
+			if size := lx.closeAction(rest); size > 0 {
+				i += size
+				continue
+			}
+		case top != nil && (!top.hole || top.syntax != nil):
+			n, col, inComment := len(state.frames), lx.col, top.comment
+			size := state.skip(lineNum, lx.col, rest)
+			lx.blank(rest[:size])
+			// The terminator of a comment is not part of its text.
+			if inComment && len(state.frames) == n {
+				lx.comment(rest[:size], col)
+			}
+			i += size
+			continue
+		}
+		if code.TeX {
+			if size := lx.tex(rest); size > 0 {
+				i += size
+				continue
+			}
+		}
+		if code.CodeEscapes && rest[0] == '\\' {
+			size := 1
+			if len(rest) > 1 {
+				_, s := utf8.DecodeRuneInString(rest[1:])
+				size += s
+			}
+			lx.blank(rest[:size])
+			i += size
+			continue
+		}
+		if code.Heredocs {
+			if f, size := heredoc(line, i); size > 0 {
+				heredocs = append(heredocs, f)
+				lx.blank(rest[:size])
+				i += size
+				continue
+			}
+		}
+		if marker := code.lineComment(line, i); marker != "" {
+			// A line comment does not hide the end of its region.
+			size := len(rest)
+			if region != nil {
+				if k := indexFold(rest, region.close); k >= 0 {
+					size = k
+				}
+			}
+			lx.comment(rest[len(marker):size], lx.col)
+			lx.blank(rest[:size])
+			i += size
+			continue
+		}
+		if top != nil && (top.hole || top.action) && state.closeHole(rest) {
+			size := len(top.close)
+			lx.pop()
+			lx.blank(rest[:size])
+			i += size
+			continue
+		}
+		if code.Markup != nil && code.Markup.Embedded && rest[0] == '<' && jsxStart(line, i) {
+			if size := lx.startTag(rest); size > 0 {
+				i += size
+				continue
+			}
+		}
+		if block := code.blockComment(rest); block[0] != "" {
+			i += lx.openComment(rest, block, code.NestedComments)
+			continue
+		}
+		if in := code.expansion(rest); in != nil {
+			lx.push(interpolation(*in, false, lineNum, lx.col))
+			lx.blank(rest[:len(in.Open)])
+			i += len(in.Open)
+			continue
+		}
+		if f, size := code.openLiteral(line, i); size > 0 {
+			f.open, f.line, f.col = rest[:size], lineNum, lx.col
+			lx.push(f)
+			lx.blank(rest[:size])
+			i += size
+			continue
+		}
+		_, size := utf8.DecodeRuneInString(rest)
+		lx.keep(rest[:size])
+		i += size
+	}
+	// Only multi-line literals and comments survive the end of the line,
+	// along with the literals whose last backslash escapes it.
+	for n := len(state.frames); n > 0 && !state.frames[n-1].multiline && !continued(state.frames[n-1], line); n-- {
+		if f := state.frames[n-1]; f.hole {
+			lx.res.diagnostics = append(lx.res.diagnostics, *unterminatedError(f))
+		}
+		state.frames = state.frames[:n-1]
+	}
+	// Here-documents start on the next line, in the order they are given.
+	for k := len(heredocs) - 1; k >= 0; k-- {
+		state.frames = append(state.frames, heredocs[k])
+	}
+	lx.res.code = line
+	if lx.masked {
+		lx.res.code = lx.sb.String()
+	}
+	return lx.res
+}
+
+// continued tells whether the literal f goes on past the end of line,
+// which escapes the line break with an odd number of backslashes.
+func continued(f lexFrame, line string) bool {
+	n := len(line) - len(strings.TrimRight(line, `\`))
+	return f.escapes && !f.hole && n%2 == 1
+}
+
+// skip consumes the start of rest, which is inside the literal or comment on
+// top of the stack, and returns its length. The literal is popped when rest
+// starts with its terminator and a hole is pushed when it opens one.
+func (s *lexState) skip(lineNum, col int, rest string) int {
+	n := len(s.frames)
+	f := s.frames[n-1]
+	if syntax := f.syntax; syntax != nil {
+		if v := hasAnyPrefix(rest, syntax.Verbatim); v != "" {
+			return len(v)
+		}
+		for _, in := range syntax.Interpolations {
+			if strings.HasPrefix(rest, in.Open) {
+				s.frames = append(s.frames, interpolation(in, f.multiline, lineNum, col))
+				return len(in.Open)
+			}
+		}
+	}
+	switch {
+	case f.raw && hasPrefixFold(rest, f.close) && (f.word == "" || closesVerbatim(rest, f)):
+		// The end tag of a raw text element is left to the markup lexer.
+		s.frames = s.frames[:n-1]
+		return 0
+	case f.raw && f.word != "" && strings.HasPrefix(rest, f.close):
+		// Any other action in a verbatim block is plain text.
+		return len(f.close)
+	case f.nested && strings.HasPrefix(rest, f.open):
+		s.frames[n-1].depth++
+		return len(f.open)
+	case f.nested && f.depth > 0 && strings.HasPrefix(rest, f.close):
+		s.frames[n-1].depth--
+		return len(f.close)
+	case f.escapes && rest[0] == '\\':
+		size := 1
+		if len(rest) > 1 {
+			_, s := utf8.DecodeRuneInString(rest[1:])
+			size += s
+		}
+		return size
+	case f.doubled && strings.HasPrefix(rest, f.close+f.close):
+		return 2 * len(f.close)
+	case strings.HasPrefix(rest, f.close):
+		s.frames = s.frames[:n-1]
+		return len(f.close)
+	}
+	_, size := utf8.DecodeRuneInString(rest)
+	return size
+}
+
+// closeHole tracks the brackets of the code in the hole on top of the stack
+// and tells whether rest starts with the closer of the hole.
+func (s *lexState) closeHole(rest string) bool {
+	f := &s.frames[len(s.frames)-1]
+	if f.action && rest[0] != BracketOpenBrace && rest[0] != BracketClosedBrace {
+		// Only braces can hide the end of an action, as in {{ {'a': 1} }}.
+		return false
+	}
+	switch rest[0] {
+	case BracketOpenRound, BracketOpenSquare, BracketOpenBrace:
+		f.depth++
+	case BracketClosedRound, BracketClosedSquare, BracketClosedBrace:
+		if f.depth == 0 {
+			return strings.HasPrefix(rest, f.close)
+		}
+		f.depth--
+	}
+	return false
+}
+
+// heredoc returns the here-document that <<WORD, <<-WORD or a quoted WORD
+// at byte i of line starts, along with the length of the redirection. A <<
+// after (( is taken for a shift in arithmetic instead.
+func heredoc(line string, i int) (lexFrame, int) {
+	rest := line[i:]
+	if !strings.HasPrefix(rest, "<<") || strings.HasPrefix(rest, "<<<") || strings.Contains(line[:i], "((") {
+		return lexFrame{}, 0
+	}
+	f := lexFrame{heredoc: true, multiline: true}
+	n := 2
+	if n < len(rest) && rest[n] == '-' {
+		f.tabs = true
+		n++
+	}
+	for n < len(rest) && (rest[n] == ' ' || rest[n] == '\t') {
+		n++
+	}
+	word := rest[n:]
+	switch {
+	case strings.HasPrefix(word, "'") || strings.HasPrefix(word, `"`):
+		end := strings.IndexByte(word[1:], word[0])
+		if end < 0 {
+			return lexFrame{}, 0
+		}
+		f.close = word[1 : end+1]
+		n += end + 2
+	default:
+		if strings.HasPrefix(word, "\\") {
+			word = word[1:]
+			n++
+		}
+		m := 0
+		for m < len(word) && (isIdentByte(word[m]) || word[m] == '-' || word[m] == '.') {
+			m++
+		}
+		if m == 0 || word[0] >= '0' && word[0] <= '9' {
+			return lexFrame{}, 0
+		}
+		f.close = word[:m]
+		n += m
+	}
+	if f.close == "" {
+		return lexFrame{}, 0
+	}
+	return f, n
+}
+
+// lineComment returns the marker of the line comment that starts at byte i
+// of line, if any.
+func (l *Language) lineComment(line string, i int) string {
+	for _, marker := range l.LineComments {
+		if !strings.HasPrefix(line[i:], marker) {
+			continue
+		}
+		if l.WordComments && i > 0 && line[i-1] != ' ' && line[i-1] != '\t' {
+			continue
+		}
+		return marker
+	}
+	return ""
+}
+
+// blockComment returns the markers of the block comment that opens at the
+// start of rest, if any.
+func (l *Language) blockComment(rest string) [2]string {
+	for _, block := range l.BlockComments {
+		if strings.HasPrefix(rest, block[0]) {
+			return block
+		}
+	}
+	return [2]string{}
+}
+
+// expansion returns the hole that opens in code at the start of rest, if
+// any.
+func (l *Language) expansion(rest string) *Interpolation {
+	for k := range l.Expansions {
+		if strings.HasPrefix(rest, l.Expansions[k].Open) {
+			return &l.Expansions[k]
+		}
+	}
+	return nil
+}
+
+// openLiteral returns the string literal that opens at byte i of line along
+// with the length of its opening, which is 0 if none does.
+func (l *Language) openLiteral(line string, i int) (lexFrame, int) {
+	for k := range l.Strings {
+		if f, n := l.Strings[k].open(line, i); n > 0 {
+			return f, n
+		}
+	}
+	return lexFrame{}, 0
+}
+
+func (s *StringSyntax) open(line string, i int) (lexFrame, int) {
+	prefixes := s.Prefixes
+	if len(prefixes) == 0 {
+		prefixes = []string{""}
+	}
+	rest := line[i:]
+	if s.NotAfterValue && i > 0 && (isIdentByte(line[i-1]) || strings.IndexByte(")]}'", line[i-1]) >= 0) {
+		return lexFrame{}, 0
+	}
+	for _, p := range prefixes {
+		if !strings.HasPrefix(rest, p) || (p != "" && i > 0 && isIdentByte(line[i-1])) {
+			continue
+		}
+		n := len(p)
+		hashes := ""
+		if s.Delimiter == DelimiterHashes {
+			hashes = rest[n : len(rest)-len(strings.TrimLeft(rest[n:], "#"))]
+			if hashes == "" && p == "" {
+				continue
+			}
+			n += len(hashes)
+		}
+		if !strings.HasPrefix(rest[n:], s.Open) {
+			continue
+		}
+		n += len(s.Open)
+		close := s.Close
+		if close == "" {
+			close = s.Open
+		}
+		switch s.Delimiter {
+		case DelimiterHashes:
+			close += hashes
+		case DelimiterParen:
+			end := strings.IndexByte(rest[n:], '(')
+			if end < 0 || end > 16 || strings.ContainsAny(rest[n:n+end], " \t\\)\"") {
+				continue
+			}
+			close = ")" + rest[n:n+end] + close
+			n += end + 1
+		case DelimiterRepeat:
+			more := len(rest[n:]) - len(strings.TrimLeft(rest[n:], s.Open[:1]))
+			close += strings.Repeat(s.Open[:1], more)
+			n += more
+		}
+		if s.Char && !charLiteral(rest[n:], close) {
+			continue
+		}
+		return lexFrame{close: close, escapes: s.Escapes, doubled: s.Doubled, multiline: s.Multiline, syntax: s}, n
+	}
+	return lexFrame{}, 0
+}
+
+// charLiteral tells whether body, which follows the opening of a character
+// literal, holds a character or an escape up to close.
+func charLiteral(body, close string) bool {
+	if len(body) > 1 && body[0] == '\\' {
+		end := strings.Index(body[2:], close)
+		return end >= 0 && end <= 10
+	}
+	r, size := utf8.DecodeRuneInString(body)
+	return size > 0 && r != '\n' && strings.HasPrefix(body[size:], close)
+}
+
+func isIdentByte(b byte) bool {
+	return b == '_' || b >= '0' && b <= '9' || b >= 'a' && b <= 'z' || b >= 'A' && b <= 'Z' || b >= 0x80
+}
@@ -0,0 +1,100 @@
+// Copyright (C) 2020 Fabio Del Vigna
+//
+// This file is part of drbracket.
+//
+// drbracket is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// drbracket is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with drbracket.  If not, see <http://www.gnu.org/licenses/>.
+
+package parser
+
+import (
+	"strings"
+	"unicode/utf8"
+)
+
+// maskFenced lexes a line of a Markdown file. Only fenced code blocks whose
+// info string names a known language are checked, with the rules of that
+// language: the fence is kept at the bottom of the lexer state and the
+// frames of the code go on top of it.
+func (l *Language) maskFenced(state *lexState, lineNum int, line string) lexedLine {
+	blank := strings.Repeat(" ", utf8.RuneCountInString(line))
+	if len(state.frames) == 0 {
+		fence, info, col := openingFence(line)
+		lang := LanguageForName(info)
+		if fence == "" || lang == nil || lang == LanguageDefault {
+			return lexedLine{code: blank, comments: l.comments(line)}
+		}
+		state.frames = append(state.frames, lexFrame{close: fence, multiline: true, region: lang, fence: true})
+		return lexedLine{code: blank, tags: []tagToken{{kind: wordOpen, name: fence, close: fence, col: col}}}
+	}
+	f := state.frames[0]
+	if col := closingFence(line, f.close); col > 0 {
+		state.frames = state.frames[:0]
+		return lexedLine{code: blank, tags: []tagToken{{kind: wordClose, name: f.close, col: col}}}
+	}
+	inner := lexState{frames: append([]lexFrame(nil), state.frames[1:]...)}
+	res := f.region.mask(&inner, lineNum, line)
+	state.frames = append(state.frames[:1], inner.frames...)
+	res.lang = f.region
+	return res
+}
+
+// openingFence returns the fence that opens a code block on line, along
+// with the first word of its info string and the column of the fence.
+func openingFence(line string) (string, string, int) {
+	body := strings.TrimLeft(line, " \t")
+	fence := fenceAt(body)
+	if fence == "" {
+		return "", "", 0
+	}
+	info := strings.TrimSpace(body[len(fence):])
+	if fence[0] == '`' && strings.Contains(info, "`") {
+		return "", "", 0
+	}
+	if fields := strings.Fields(info); len(fields) > 0 {
+		info = strings.Trim(fields[0], "{}.")
+	}
+	return fence, info, len(line) - len(body) + 1
+}
+
+// closingFence returns the column of the fence that closes a block opened
+// by open, or 0 if line does not close it.
+func closingFence(line, open string) int {
+	body := strings.TrimLeft(line, " \t")
+	fence := fenceAt(body)
+	if fence == "" || fence[0] != open[0] || len(fence) < len(open) || strings.TrimSpace(body[len(fence):]) != "" {
+		return 0
+	}
+	return len(line) - len(body) + 1
+}
+
+// fenceAt returns the run of three or more backticks or tildes at the start
+// of s.
+func fenceAt(s string) string {
+	if s == "" || (s[0] != '`' && s[0] != '~') {
+		return ""
+	}
+	n := 1
+	for n < len(s) && s[n] == s[0] {
+		n++
+	}
+	if n < 3 {
+		return ""
+	}
+	return s[:n]
+}
+
+// isFence tells the brackets that stand for the fences of code blocks.
+func isFence(b Bracket) bool {
+	return fenceAt(b.Name) != ""
+}
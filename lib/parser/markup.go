@@ -0,0 +1,440 @@
+// Copyright (C) 2020 Fabio Del Vigna
+//
+// This file is part of drbracket.
+//
+// drbracket is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// drbracket is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with drbracket.  If not, see <http://www.gnu.org/licenses/>.
+
+package parser
+
+import (
+	"strings"
+	"unicode/utf8"
+)
+
+// Markup describes the tags of a markup language. Embedded is set when
+// elements appear among code, as in JSX, rather than making up the whole
+// file. Element names that are not case sensitive are folded to lower
+// case.
+type Markup struct {
+	Embedded   bool
+	IgnoreCase bool
+	// Void elements have no end tag, elements with an OptionalEnd may be
+	// left open and the contents of RawText elements are not markup.
+	Void        []string
+	OptionalEnd []string
+	RawText     []string
+	Regions     []Region
+}
+
+// Region is a part of a markup file written in another language: the
+// contents of an Element, or the text from Open to Close. The brackets of an
+// element cannot be closed outside of it, while the regions delimited by
+// Open and Close make up a single program, as the PHP blocks of a page do:
+// their brackets are matched on a stack of their own, apart from the tags
+// around them, so that a brace opened in one block may be closed in a later
+// one.
+type Region struct {
+	Element  string
+	Open     string
+	Close    string
+	Language *Language
+}
+
+func (m *Markup) element(name string) *Region {
+	for i := range m.Regions {
+		if m.Regions[i].Element == name {
+			return &m.Regions[i]
+		}
+	}
+	return nil
+}
+
+type tagKind int
+
+const (
+	tagOpen tagKind = iota
+	tagClose
+	tagSelfClose
+	wordOpen
+	wordClose
+	wordToggle
+	regionOpen
+	regionClose
+)
+
+// tagToken is a tag found by the lexer at column col of a line, or a word
+// that the lexer makes a bracket of, as the fences of Markdown code blocks
+// or the environments of TeX. Names are empty for the fragments of JSX, and
+// close is the word that closes a word opened. Words that take effect away
+// from where they are written, as the block keywords of template actions,
+// are reported at line and at instead.
+type tagToken struct {
+	kind  tagKind
+	name  string
+	close string
+	col   int
+	line  int
+	at    int
+}
+
+func (t tagToken) bracket(lineNum int) Bracket {
+	col := t.col
+	if t.at > 0 {
+		lineNum, col = t.line, t.at
+	}
+	switch t.kind {
+	case wordOpen, wordToggle:
+		return Bracket{Name: t.name, Close: t.close, Line: lineNum, Col: col}
+	case wordClose:
+		return Bracket{Name: t.name, Line: lineNum, Col: col}
+	case tagOpen:
+		return Bracket{Name: "<" + t.name + ">", Close: "</" + t.name + ">", Line: lineNum, Col: col}
+	}
+	return Bracket{Name: "</" + t.name + ">", Line: lineNum, Col: col}
+}
+
+func (lx *lexer) emit(kind tagKind, name string) {
+	lx.res.tags = append(lx.res.tags, tagToken{kind: kind, name: name, col: lx.col})
+}
+
+// text lexes the start of rest as the text between tags, which is blanked
+// out except for the braces that open JSX expressions.
+func (lx *lexer) text(rest string) int {
+	m := lx.lang.Markup
+	for _, r := range m.Regions {
+		if r.Open != "" && hasPrefixFold(rest, r.Open) {
+			lx.emit(regionOpen, "")
+			lx.push(lexFrame{close: r.Close, multiline: true, region: r.Language})
+			lx.blank(rest[:len(r.Open)])
+			return len(r.Open)
+		}
+	}
+	if !m.Embedded {
+		if block := lx.lang.blockComment(rest); block[0] != "" {
+			return lx.openComment(rest, block, false)
+		}
+	}
+	switch {
+	case rest[0] == '<':
+		if size := lx.startTag(rest); size > 0 {
+			return size
+		}
+		if size := lx.endTag(rest); size > 0 {
+			return size
+		}
+	case m.Embedded && rest[0] == BracketOpenBrace:
+		lx.hole("{", "}", true)
+		lx.blank(rest[:1])
+		return 1
+	}
+	_, size := utf8.DecodeRuneInString(rest)
+	lx.blank(rest[:size])
+	return size
+}
+
+// startTag lexes the start tag at the start of rest, if there is one.
+func (lx *lexer) startTag(rest string) int {
+	m := lx.lang.Markup
+	if m.Embedded && strings.HasPrefix(rest, "<>") {
+		lx.emit(tagOpen, "")
+		lx.push(lexFrame{children: true, multiline: true})
+		lx.blank(rest[:2])
+		return 2
+	}
+	name := tagName(rest[1:])
+	if name == "" {
+		return 0
+	}
+	size := 1 + len(name)
+	if m.IgnoreCase {
+		name = strings.ToLower(name)
+	}
+	void := contains(m.Void, name)
+	if !void {
+		lx.emit(tagOpen, name)
+	}
+	lx.push(lexFrame{tag: true, name: name, void: void, multiline: true})
+	lx.blank(rest[:size])
+	return size
+}
+
+// endTag lexes the end tag at the start of rest, if there is one. In JSX an
+// end tag also ends the children of the element it closes, or of the
+// innermost element if none matches.
+func (lx *lexer) endTag(rest string) int {
+	m := lx.lang.Markup
+	if !strings.HasPrefix(rest, "</") {
+		return 0
+	}
+	fragment := m.Embedded && strings.HasPrefix(rest, "</>")
+	name, size := "", 3
+	if !fragment {
+		name = tagName(rest[2:])
+		if name == "" {
+			return 0
+		}
+		size = 2 + len(name)
+		if m.IgnoreCase {
+			name = strings.ToLower(name)
+		}
+	}
+	if m.Embedded {
+		lx.endChildren(name)
+	}
+	lx.emit(tagClose, name)
+	if !fragment {
+		lx.push(lexFrame{tag: true, end: true, name: name, multiline: true})
+	}
+	lx.blank(rest[:size])
+	return size
+}
+
+func (lx *lexer) endChildren(name string) {
+	frames := lx.state.frames
+	for i := len(frames) - 1; i >= 0 && frames[i].children; i-- {
+		if frames[i].name == name {
+			lx.state.frames = frames[:i]
+			return
+		}
+	}
+	if top := lx.top(); top != nil && top.children {
+		lx.pop()
+	}
+}
+
+// tag lexes the start of rest inside a tag, where attribute values are
+// literals and JSX expressions may appear in braces.
+func (lx *lexer) tag(rest string) int {
+	m := lx.lang.Markup
+	f := *lx.top()
+	switch {
+	case strings.HasPrefix(rest, "/>"):
+		lx.pop()
+		if !f.end && !f.void {
+			lx.emit(tagSelfClose, f.name)
+		}
+		lx.blank(rest[:2])
+		return 2
+	case rest[0] == '>':
+		lx.pop()
+		switch {
+		case f.end || f.void:
+		case m.element(f.name) != nil:
+			lx.push(lexFrame{close: "</" + f.name, raw: true, multiline: true, region: m.element(f.name).Language})
+		case contains(m.RawText, f.name):
+			lx.push(lexFrame{close: "</" + f.name, raw: true, multiline: true})
+		case m.Embedded:
+			lx.push(lexFrame{children: true, name: f.name, multiline: true})
+		}
+		lx.blank(rest[:1])
+		return 1
+	case rest[0] == '"' || rest[0] == '\'':
+		lx.push(lexFrame{close: rest[:1], multiline: true})
+		lx.blank(rest[:1])
+		return 1
+	case m.Embedded && rest[0] == BracketOpenBrace:
+		lx.hole("{", "}", true)
+		lx.blank(rest[:1])
+		return 1
+	}
+	_, size := utf8.DecodeRuneInString(rest)
+	lx.blank(rest[:size])
+	return size
+}
+
+// region returns the frame of the innermost region being lexed, if any.
+func (lx *lexer) region() *lexFrame {
+	for i := len(lx.state.frames) - 1; i >= 0; i-- {
+		if lx.state.frames[i].region != nil {
+			return &lx.state.frames[i]
+		}
+	}
+	return nil
+}
+
+// leaveRegion ends the region on top of the stack if rest starts with its
+// end. The end tag of an element is left to the markup lexer.
+func (lx *lexer) leaveRegion(rest string) (int, bool) {
+	f := *lx.top()
+	if !hasPrefixFold(rest, f.close) {
+		return 0, false
+	}
+	lx.pop()
+	if f.raw {
+		return 0, true
+	}
+	lx.emit(regionClose, "")
+	lx.blank(rest[:len(f.close)])
+	return len(f.close), true
+}
+
+func hasPrefixFold(s, prefix string) bool {
+	return len(s) >= len(prefix) && strings.EqualFold(s[:len(prefix)], prefix)
+}
+
+func indexFold(s, sub string) int {
+	for k := 0; k+len(sub) <= len(s); k++ {
+		if strings.EqualFold(s[k:k+len(sub)], sub) {
+			return k
+		}
+	}
+	return -1
+}
+
+// tagName returns the element name at the start of s.
+func tagName(s string) string {
+	if s == "" || !(isLetter(s[0]) || s[0] == '_') {
+		return ""
+	}
+	n := 1
+	for n < len(s) && (isIdentByte(s[n]) || s[n] == '-' || s[n] == ':' || s[n] == '.') {
+		n++
+	}
+	return s[:n]
+}
+
+func isLetter(b byte) bool {
+	return (b >= 'a' && b <= 'z') || (b >= 'A' && b <= 'Z')
+}
+
+// jsxStart tells whether the < at byte i of line starts an element rather
+// than a comparison or a type argument, judging from what precedes it.
+func jsxStart(line string, i int) bool {
+	next := line[i+1:]
+	if next == "" || !(isLetter(next[0]) || next[0] == '>') {
+		return false
+	}
+	before := strings.TrimRight(line[:i], " \t")
+	if before == "" || strings.ContainsAny(before[len(before)-1:], "(=,[{:?&|>!;") {
+		return true
+	}
+	return strings.HasSuffix(before, "return") &&
+		(len(before) == len("return") || !isIdentByte(before[len(before)-len("return")-1]))
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// implied tells the elements whose end tag may be left out.
+func (l *Language) implied(b Bracket) bool {
+	if l.Markup == nil || !strings.HasPrefix(b.Name, "<") {
+		return false
+	}
+	return contains(l.Markup.OptionalEnd, strings.Trim(b.Name, "<>"))
+}
+
+// isolated tells the elements whose contents are a region of their own, and
+// the fences of Markdown code blocks.
+func (l *Language) isolated(b Bracket) bool {
+	if isFence(b) {
+		return true
+	}
+	if l.Markup == nil || !strings.HasPrefix(b.Name, "<") {
+		return false
+	}
+	return l.Markup.element(strings.Trim(b.Name, "<>")) != nil
+}
+
+func (p *BracketParser) isolates(b Bracket) bool {
+	return p.isolated != nil && p.isolated(b)
+}
+
+// closeRegion closes with c the innermost isolated element, if c is its
+// end tag, reporting the brackets left open inside it as unclosed.
+func (p *BracketParser) closeRegion(c Bracket) (*Diagnostic, bool) {
+	for i := len(p.stack) - 1; i >= 0; i-- {
+		open := p.stack[i]
+		if !p.isolates(open) {
+			continue
+		}
+		if open.Closer() != c.String() {
+			return nil, false
+		}
+		var first *Diagnostic
+		for _, b := range p.stack[i+1:] {
+			if d := p.report(unclosedError(b)); first == nil {
+				first = d
+			}
+		}
+		p.drop(p.stack[i+1:]...)
+		p.stack = p.stack[:i]
+		p.pair(open, c)
+		return first, true
+	}
+	return nil, false
+}
+
+// pushTag opens an element. An element whose end tag may be left out is
+// closed by the next element of the same kind, as consecutive li or td
+// elements are, along with the ones opened inside it whose end tags may be
+// left out as well.
+func (p *BracketParser) pushTag(b Bracket) {
+	if p.implied != nil && p.implied(b) {
+		for i := len(p.stack) - 1; i >= 0 && p.implied(p.stack[i]); i-- {
+			if p.stack[i].Name == b.Name {
+				p.stack = p.stack[:i]
+				break
+			}
+		}
+	}
+	p.Push(b)
+}
+
+// selfClose closes the element that a tag ending in /> opened.
+func (p *BracketParser) selfClose(name string) {
+	if top := p.Top(); top != nil && top.Name == "<"+name+">" {
+		p.Pop()
+	}
+}
+
+func (p *BracketParser) parseTag(lineNum int, t tagToken) {
+	switch t.kind {
+	case tagOpen:
+		p.pushTag(t.bracket(lineNum))
+	case wordOpen:
+		p.Push(t.bracket(lineNum))
+	case wordToggle:
+		p.toggle(t.bracket(lineNum))
+	case tagClose, wordClose:
+		_ = p.closeBracket(t.bracket(lineNum))
+	case tagSelfClose:
+		p.selfClose(t.name)
+	case regionOpen, regionClose:
+		p.swap()
+	}
+}
+
+// swap exchanges the stack of tags with the one of the brackets of the
+// regions that make up a single program.
+func (p *BracketParser) swap() {
+	p.stack, p.shared = p.shared, p.stack
+	p.swapped = !p.swapped
+}
+
+// shared tells whether lexing the next line starts in a region whose
+// brackets are matched apart from the tags.
+func (s lexState) shared() bool {
+	for _, f := range s.frames {
+		if f.region != nil && !f.raw && !f.fence {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,182 @@
+// Copyright (C) 2020 Fabio Del Vigna
+//
+// This file is part of drbracket.
+//
+// drbracket is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// drbracket is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with drbracket.  If not, see <http://www.gnu.org/licenses/>.
+
+package parser
+
+import (
+	"sort"
+	"strings"
+)
+
+// Pair is a matched couple of brackets. Offsets are byte offsets in the
+// indexed text and Depth is 0 for pairs that are not nested in any other.
+type Pair struct {
+	Open        Bracket
+	Close       Bracket
+	OpenOffset  int
+	CloseOffset int
+	Depth       int
+}
+
+func (p *Pair) Contains(offset int) bool {
+	return offset >= p.OpenOffset && offset <= p.CloseOffset
+}
+
+// PairIndex answers the bracket matching queries of editors: the partner of
+// a bracket, the pair enclosing a position and the pairs at a given depth.
+type PairIndex struct {
+	pairs     []Pair
+	byOffset  map[int]int
+	unmatched []Bracket
+	lines     []int
+	text      string
+}
+
+// NewPairIndex indexes the brackets of text as the checker sees them with
+// the rules of lang, so that those in comments and literals are left out.
+func NewPairIndex(lang *Language, text string) *PairIndex {
+	if lang == nil {
+		lang = LanguageDefault
+	}
+	idx := &PairIndex{
+		byOffset: make(map[int]int),
+		lines:    []int{0},
+		text:     text,
+	}
+	for i := 0; i < len(text); i++ {
+		if text[i] == '\n' {
+			idx.lines = append(idx.lines, i+1)
+		}
+	}
+
+	p := NewBracketParser()
+	p.pairs = make([]Pair, 0)
+	p.unmatched = make([]Bracket, 0)
+	var lex lexState
+	for n := range idx.lines {
+		if lexed, parsed := lang.lex(&lex, n+1, idx.line(n+1)); parsed {
+			lang.parseCode(p, n+1, lexed)
+		}
+	}
+	_ = p.Finish()
+	idx.pairs = p.pairs
+	idx.unmatched = p.unmatched
+	sort.Slice(idx.unmatched, func(i, j int) bool {
+		return before(idx.unmatched[i], idx.unmatched[j])
+	})
+
+	sort.Slice(idx.pairs, func(i, j int) bool {
+		return before(idx.pairs[i].Open, idx.pairs[j].Open)
+	})
+	for i := range idx.pairs {
+		pair := &idx.pairs[i]
+		pair.OpenOffset = idx.Offset(pair.Open.Line, pair.Open.Col)
+		pair.CloseOffset = idx.Offset(pair.Close.Line, pair.Close.Col)
+		idx.byOffset[pair.OpenOffset] = i
+		idx.byOffset[pair.CloseOffset] = i
+	}
+	return idx
+}
+
+func before(a, b Bracket) bool {
+	return a.Line < b.Line || (a.Line == b.Line && a.Col < b.Col)
+}
+
+func (idx *PairIndex) line(n int) string {
+	start := idx.lines[n-1]
+	end := len(idx.text)
+	if n < len(idx.lines) {
+		end = idx.lines[n] - 1
+	}
+	return strings.TrimSuffix(idx.text[start:end], "\r")
+}
+
+// Offset converts a 1-based line and rune column to a byte offset.
+func (idx *PairIndex) Offset(line, col int) int {
+	if line < 1 {
+		return 0
+	}
+	if line > len(idx.lines) {
+		return len(idx.text)
+	}
+	start := idx.lines[line-1]
+	n := 1
+	for i := range idx.line(line) {
+		if n == col {
+			return start + i
+		}
+		n++
+	}
+	return start + len(idx.line(line))
+}
+
+// Position converts a byte offset to a 1-based line and rune column.
+func (idx *PairIndex) Position(offset int) (int, int) {
+	line := sort.Search(len(idx.lines), func(i int) bool { return idx.lines[i] > offset })
+	if line == 0 {
+		return 1, 1
+	}
+	start := idx.lines[line-1]
+	if offset > len(idx.text) {
+		offset = len(idx.text)
+	}
+	return line, len([]rune(idx.text[start:offset])) + 1
+}
+
+func (idx *PairIndex) Pairs() []Pair {
+	return idx.pairs
+}
+
+// Unmatched returns the brackets that are not part of any pair.
+func (idx *PairIndex) Unmatched() []Bracket {
+	return idx.unmatched
+}
+
+// Match returns the pair of the bracket at offset, if there is a matched
+// bracket there. The partner is the side of the pair not at offset.
+func (idx *PairIndex) Match(offset int) (*Pair, bool) {
+	i, ok := idx.byOffset[offset]
+	if !ok {
+		return nil, false
+	}
+	return &idx.pairs[i], true
+}
+
+// Enclosing returns the innermost pair that contains offset, brackets
+// included.
+func (idx *PairIndex) Enclosing(offset int) (*Pair, bool) {
+	// Pairs are sorted by opening offset and properly nested, so the last
+	// opened pair that is still open at offset is the innermost.
+	last := sort.Search(len(idx.pairs), func(i int) bool { return idx.pairs[i].OpenOffset > offset })
+	for i := last - 1; i >= 0; i-- {
+		if idx.pairs[i].Contains(offset) {
+			return &idx.pairs[i], true
+		}
+	}
+	return nil, false
+}
+
+// AtDepth returns the pairs nested in exactly depth other pairs.
+func (idx *PairIndex) AtDepth(depth int) []Pair {
+	res := make([]Pair, 0)
+	for _, pair := range idx.pairs {
+		if pair.Depth == depth {
+			res = append(res, pair)
+		}
+	}
+	return res
+}
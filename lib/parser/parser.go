@@ -17,9 +17,7 @@
 
 package parser
 
-import (
-	"fmt"
-)
+import "strings"
 
 const (
 	BracketOpenRound    = '('
@@ -32,28 +30,75 @@ const (
 	BracketCloseAngular = '>'
 )
 
-func expectedOpen(b rune) rune {
+func ExpectedClose(b rune) rune {
 	switch b {
-	case BracketClosedRound:
-		return BracketOpenRound
-	case BracketClosedSquare:
-		return BracketOpenSquare
-	case BracketClosedBrace:
-		return BracketOpenBrace
-	case BracketCloseAngular:
-		return BracketOpenAngular
+	case BracketOpenRound:
+		return BracketClosedRound
+	case BracketOpenSquare:
+		return BracketClosedSquare
+	case BracketOpenBrace:
+		return BracketClosedBrace
+	case BracketOpenAngular:
+		return BracketCloseAngular
 	}
 	panic("Unknown bracket kind")
 }
 
+// Bracket is a bracket rune of Kind, or a word that opens or closes a block,
+// such as if and fi in shell scripts. Words have a Name instead of a Kind,
+// and the ones that open a block the Close word that ends it.
 type Bracket struct {
-	Kind rune
-	Line int
-	Col  int
+	Kind  rune
+	Name  string
+	Close string
+	Line  int
+	Col   int
+}
+
+func (b Bracket) String() string {
+	if b.Name != "" {
+		return b.Name
+	}
+	return string(b.Kind)
+}
+
+// Noun names what b opens or closes, a bracket, a tag or a block.
+func (b Bracket) Noun() string {
+	if strings.HasPrefix(b.Name, "<") {
+		return "tag"
+	}
+	if b.Name != "" {
+		return "block"
+	}
+	return "bracket"
+}
+
+// Closer returns the text of the bracket that closes b.
+func (b Bracket) Closer() string {
+	if b.Name != "" {
+		return b.Close
+	}
+	return string(ExpectedClose(b.Kind))
 }
 
 type BracketParser struct {
-	stack []Bracket
+	stack       []Bracket
+	diagnostics []Diagnostic
+	pairs       []Pair
+	unmatched   []Bracket
+	// absorbs tells the closing brackets that stand alone right inside a
+	// block, as the ) of shell case patterns.
+	absorbs func(open, c Bracket) bool
+	// implied tells the openers that may be left unclosed, as the HTML
+	// elements whose end tag is optional.
+	implied func(open Bracket) bool
+	// isolated tells the openers of regions that brackets cannot be
+	// closed across, as the script elements of HTML.
+	isolated func(open Bracket) bool
+	// shared holds the brackets of the regions that make up a single
+	// program, as the PHP blocks of a page, while tags are parsed.
+	shared  []Bracket
+	swapped bool
 }
 
 func NewBracketParser() *BracketParser {
@@ -86,35 +131,145 @@ func (p *BracketParser) Push(b Bracket) {
 	p.stack = append(p.stack, b)
 }
 
-func bracketError(found rune, lineFound, colFound int, expected rune, lineExpected, colExpected int) error {
-	return fmt.Errorf("Unbalanced bracket. Found %c at line: %d, col: %d. Expected %c from line: %d, col: %d",
-		found, lineFound, colFound, expected, lineExpected, colExpected)
+func (p *BracketParser) report(d *Diagnostic) *Diagnostic {
+	p.diagnostics = append(p.diagnostics, *d)
+	return d
+}
+
+// closeBracket matches c against the open brackets. On a mismatch the stack
+// is unwound down to an opener of the same kind, if any, so that a single
+// missing or extra bracket is reported once rather than on every later line.
+// The stack is never unwound past the opener of an isolated region.
+// Without such an opener c is taken as a typo for the closer of an opener on
+// the same line, and as a stray bracket otherwise.
+func (p *BracketParser) closeBracket(c Bracket) *Diagnostic {
+	p.closeImplied(c)
+	b := p.Top()
+	if b == nil {
+		p.drop(c)
+		return p.report(unexpectedError(c))
+	}
+	if b.Closer() == c.String() {
+		p.pair(*p.Pop(), c)
+		return nil
+	}
+	if p.absorbs != nil && p.absorbs(*b, c) {
+		return nil
+	}
+	if p.isolates(*b) {
+		p.drop(c)
+		return p.report(unexpectedError(c))
+	}
+	if d, ok := p.closeRegion(c); ok {
+		return d
+	}
+	d := p.report(bracketError(c, *b))
+	for i := len(p.stack) - 2; i >= 0 && !p.isolates(p.stack[i+1]); i-- {
+		if p.stack[i].Closer() == c.String() {
+			open := p.stack[i]
+			p.drop(p.stack[i+1:]...)
+			p.stack = p.stack[:i]
+			p.pair(open, c)
+			return d
+		}
+	}
+	p.drop(c)
+	if b.Line == c.Line {
+		p.drop(*p.Pop())
+	}
+	return d
+}
+
+// closeImplied closes the openers that may be left unclosed when c matches
+// the one right below them.
+func (p *BracketParser) closeImplied(c Bracket) {
+	if p.implied == nil {
+		return
+	}
+	for i := len(p.stack) - 1; i > 0 && p.implied(p.stack[i]); i-- {
+		if p.stack[i-1].Closer() == c.String() {
+			p.stack = p.stack[:i]
+			return
+		}
+	}
+}
+
+// pair records a matched bracket pair when the parser backs a PairIndex.
+func (p *BracketParser) pair(open, c Bracket) {
+	if p.pairs == nil {
+		return
+	}
+	p.pairs = append(p.pairs, Pair{
+		Open:  open,
+		Close: c,
+		Depth: len(p.stack),
+	})
+}
+
+// drop records brackets left without a partner when the parser backs a
+// PairIndex.
+func (p *BracketParser) drop(b ...Bracket) {
+	if p.pairs == nil {
+		return
+	}
+	p.unmatched = append(p.unmatched, b...)
 }
 
+// ParseLine feeds a line to the parser. Every problem found is recorded in
+// Diagnostics and the first one of the line is also returned.
 func (p *BracketParser) ParseLine(lineNum int, line string) error {
-	for col, c := range line {
-		switch c {
-		case BracketOpenRound:
-			fallthrough
-		case BracketOpenSquare:
-			fallthrough
-		case BracketOpenBrace:
-			//fallthrough
-			//case BracketOpenAngular:
-			p.Push(Bracket{Kind: c, Line: lineNum, Col: col + 1})
-		case BracketClosedRound:
-			fallthrough
-		case BracketClosedSquare:
-			fallthrough
-		case BracketClosedBrace:
-			//fallthrough
-			//case BracketCloseAngular:
-			if b := p.Top(); p != nil && b.Kind != expectedOpen(c) {
-				return bracketError(c, lineNum, col+1, b.Kind, b.Line, b.Col)
-			}
-			_ = p.Pop()
-		default:
+	var first error
+	col := 0
+	for _, c := range line {
+		if d := p.parseRune(c, lineNum, col+1); d != nil && first == nil {
+			first = d
 		}
+		col++
+	}
+	return first
+}
+
+func (p *BracketParser) parseRune(c rune, lineNum, col int) *Diagnostic {
+	switch c {
+	case BracketOpenRound:
+		fallthrough
+	case BracketOpenSquare:
+		fallthrough
+	case BracketOpenBrace:
+		//fallthrough
+		//case BracketOpenAngular:
+		p.Push(Bracket{Kind: c, Line: lineNum, Col: col})
+	case BracketClosedRound:
+		fallthrough
+	case BracketClosedSquare:
+		fallthrough
+	case BracketClosedBrace:
+		//fallthrough
+		//case BracketCloseAngular:
+		return p.closeBracket(Bracket{Kind: c, Line: lineNum, Col: col})
+	default:
 	}
 	return nil
 }
+
+// Finish reports the brackets left open at the end of the input and returns
+// all the diagnostics collected so far.
+func (p *BracketParser) Finish() []Diagnostic {
+	for _, b := range p.stack {
+		if p.implied == nil || !p.implied(b) {
+			p.report(unclosedError(b))
+		}
+	}
+	for _, b := range p.shared {
+		p.report(unclosedError(b))
+	}
+	p.drop(p.stack...)
+	p.drop(p.shared...)
+	p.stack = p.stack[:0]
+	p.shared = nil
+	return p.diagnostics
+}
+
+func (p *BracketParser) Diagnostics() []Diagnostic {
+	return p.diagnostics
+}
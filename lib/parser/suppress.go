@@ -0,0 +1,140 @@
+// Copyright (C) 2020 Fabio Del Vigna
+//
+// This file is part of drbracket.
+//
+// drbracket is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// drbracket is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with drbracket.  If not, see <http://www.gnu.org/licenses/>.
+
+package parser
+
+import (
+	"strings"
+)
+
+const directivePrefix = "drbracket:"
+
+const (
+	DirectiveIgnoreNextLine = "ignore-next-line"
+	DirectiveIgnoreLine     = "ignore-line"
+	DirectiveOff            = "off"
+	DirectiveOn             = "on"
+	DirectiveIgnoreFile     = "ignore-file"
+)
+
+type Directive struct {
+	Name string
+	Line int
+	Col  int
+}
+
+// directives returns the drbracket directives found in the comments of a
+// line, as the lexer found them: text in string literals is not taken for
+// one.
+func directives(lineNum int, comments []comment) []Directive {
+	directives := make([]Directive, 0)
+	for _, c := range comments {
+		for _, field := range strings.Fields(c.text) {
+			if !strings.HasPrefix(field, directivePrefix) {
+				continue
+			}
+			name := strings.TrimRight(strings.TrimPrefix(field, directivePrefix), ".,;:")
+			switch name {
+			case DirectiveIgnoreNextLine, DirectiveIgnoreLine, DirectiveOff, DirectiveOn, DirectiveIgnoreFile:
+				directives = append(directives, Directive{Name: name, Line: lineNum, Col: c.col})
+			}
+		}
+	}
+	return directives
+}
+
+// suppressionState is what the directives seen so far imply for the next
+// line. It is a plain value so that it can be checkpointed along with the
+// bracket stack.
+type suppressionState struct {
+	off  *Directive
+	next *Directive
+}
+
+// apply updates the state with the directives of a line and returns the
+// directive that suppresses the line, if any, along with the directives of
+// the line that started a suppression.
+func (s *suppressionState) apply(directives []Directive) (*Directive, []Directive) {
+	var active *Directive
+	var started []Directive
+	if s.next != nil {
+		active, s.next = s.next, nil
+	}
+	for i := range directives {
+		d := &directives[i]
+		switch d.Name {
+		case DirectiveIgnoreLine:
+			active = d
+			started = append(started, *d)
+		case DirectiveIgnoreNextLine:
+			s.next = d
+			started = append(started, *d)
+		case DirectiveOff:
+			if s.off == nil {
+				s.off = d
+				started = append(started, *d)
+			}
+		case DirectiveOn:
+			if s.off != nil && active == nil {
+				active = s.off
+			}
+			s.off = nil
+		}
+	}
+	if active == nil {
+		active = s.off
+	}
+	return active, started
+}
+
+func (s suppressionState) equal(o suppressionState, line, delta int) bool {
+	return sameDirective(s.off, o.off, line, delta) && sameDirective(s.next, o.next, line, delta)
+}
+
+func (s suppressionState) shift(line, delta int) suppressionState {
+	return suppressionState{
+		off:  shiftDirective(s.off, line, delta),
+		next: shiftDirective(s.next, line, delta),
+	}
+}
+
+func shiftDirective(d *Directive, line, delta int) *Directive {
+	if d == nil || d.Line <= line {
+		return d
+	}
+	moved := *d
+	moved.Line += delta
+	return &moved
+}
+
+func sameDirective(a, b *Directive, line, delta int) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return *a == *shiftDirective(b, line, delta)
+}
+
+func unusedError(d Directive) Diagnostic {
+	u := &Diagnostic{
+		Kind:     DiagnosticUnusedSuppression,
+		Severity: SeverityWarning,
+		Line:     d.Line,
+		Col:      d.Col,
+		Found:    directivePrefix + d.Name,
+	}
+	return *u.describe()
+}
@@ -0,0 +1,168 @@
+// Copyright (C) 2020 Fabio Del Vigna
+//
+// This file is part of drbracket.
+//
+// drbracket is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// drbracket is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with drbracket.  If not, see <http://www.gnu.org/licenses/>.
+
+package parser
+
+import (
+	"strings"
+	"unicode/utf8"
+)
+
+// Template describes a template language: the delimiters of its actions and
+// comments, and the keywords that open and close blocks when they start an
+// action. Sections is set when any #name or ^name opens a block that /name
+// closes, as in Handlebars and Mustache. The text around actions is not
+// checked, while the brackets inside actions are. The blocks opened by the
+// Verbatim keywords hold text only, up to the action that closes them, as
+// {% raw %} in Jinja.
+type Template struct {
+	Actions  [][2]string
+	Comments [][2]string
+	Blocks   []KeywordPair
+	Sections bool
+	Verbatim []string
+}
+
+// trimMarkers may follow the opening delimiter of an action or precede its
+// closing one, to trim the whitespace around it.
+const trimMarkers = "-+~"
+
+// templateText lexes the start of rest as the text around actions.
+func (lx *lexer) templateText(rest string) int {
+	t := lx.lang.Template
+	for _, c := range t.Comments {
+		if strings.HasPrefix(rest, c[0]) {
+			return lx.openComment(rest, c, false)
+		}
+	}
+	for _, a := range t.Actions {
+		if strings.HasPrefix(rest, a[0]) {
+			return lx.openAction(rest, a[0], a[1])
+		}
+	}
+	_, size := utf8.DecodeRuneInString(rest)
+	lx.blank(rest[:size])
+	return size
+}
+
+// openAction opens the action that starts rest. A keyword that closes a
+// block does so before the action opens, while one that opens a block does
+// so once the action is closed.
+func (lx *lexer) openAction(rest, open, close string) int {
+	size := len(open)
+	if size < len(rest) && strings.IndexByte(trimMarkers, rest[size]) >= 0 {
+		size++
+	}
+	f := lexFrame{close: close, multiline: true, action: true, open: open}
+	if word, at := lx.lang.Template.keyword(rest, size); word != "" {
+		col := lx.col + utf8.RuneCountInString(rest[:at])
+		if opens, closer := lx.lang.Template.block(word); opens {
+			f.name, f.word, f.line, f.col = word, closer, lx.lineNum, col
+		} else if closer != "" {
+			lx.res.tags = append(lx.res.tags, tagToken{kind: wordClose, name: closer, col: lx.col, line: lx.lineNum, at: col})
+		}
+	}
+	lx.res.tags = append(lx.res.tags, tagToken{kind: wordOpen, name: open, close: close, col: lx.col})
+	lx.push(f)
+	lx.blank(rest[:size])
+	return size
+}
+
+// closeAction closes the action on top of the stack if rest starts with its
+// closing delimiter, outside of any bracket opened in the action.
+func (lx *lexer) closeAction(rest string) int {
+	f := *lx.top()
+	size := len(f.close)
+	if strings.IndexByte(trimMarkers, rest[0]) >= 0 && strings.HasPrefix(rest[1:], f.close) {
+		size++
+	} else if !strings.HasPrefix(rest, f.close) {
+		return 0
+	}
+	if f.depth > 0 && rest[0] == BracketClosedBrace {
+		return 0
+	}
+	lx.pop()
+	lx.res.tags = append(lx.res.tags, tagToken{kind: wordClose, name: f.close, col: lx.col})
+	if f.name != "" {
+		lx.res.tags = append(lx.res.tags, tagToken{kind: wordOpen, name: f.name, close: f.word, col: lx.col, line: f.line, at: f.col})
+		if contains(lx.lang.Template.Verbatim, f.name) {
+			lx.push(lexFrame{close: f.open, word: f.word, raw: true, multiline: true})
+		}
+	}
+	lx.blank(rest[:size])
+	return size
+}
+
+// closesVerbatim tells whether rest, which starts with the opening delimiter
+// of an action, starts the one that ends the verbatim block f.
+func closesVerbatim(rest string, f lexFrame) bool {
+	i := len(f.close)
+	if i < len(rest) && strings.IndexByte(trimMarkers, rest[i]) >= 0 {
+		i++
+	}
+	word, _ := (&Template{}).keyword(rest, i)
+	return word == f.word
+}
+
+// keyword returns the word that starts the action whose text begins at
+// byte i of rest, section marker included, along with its offset.
+func (t *Template) keyword(rest string, i int) (string, int) {
+	for i < len(rest) && (rest[i] == ' ' || rest[i] == '\t') {
+		i++
+	}
+	start := i
+	if t.Sections && i < len(rest) && strings.IndexByte("#^/", rest[i]) >= 0 {
+		i++
+		if i < len(rest) && rest[i] == '>' {
+			i++
+		}
+		for i < len(rest) && rest[i] == ' ' {
+			i++
+		}
+	}
+	end := i
+	for end < len(rest) && (isIdentByte(rest[end]) || rest[end] == '-') {
+		end++
+	}
+	if end == i {
+		return "", 0
+	}
+	return rest[start:end], start
+}
+
+// block tells whether word opens a block, and returns the word that closes
+// it, or the word that word closes.
+func (t *Template) block(word string) (bool, string) {
+	if t.Sections {
+		name := strings.TrimLeft(word[1:], "> ")
+		switch word[0] {
+		case '#', '^':
+			return true, "/" + name
+		case '/':
+			return false, "/" + name
+		}
+	}
+	for _, k := range t.Blocks {
+		switch word {
+		case k.Open:
+			return true, k.Close
+		case k.Close:
+			return false, k.Close
+		}
+	}
+	return false, ""
+}
@@ -0,0 +1,154 @@
+// Copyright (C) 2020 Fabio Del Vigna
+//
+// This file is part of drbracket.
+//
+// drbracket is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// drbracket is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with drbracket.  If not, see <http://www.gnu.org/licenses/>.
+
+package parser
+
+import (
+	"strings"
+	"unicode/utf8"
+)
+
+// verbatimEnvironments are the TeX environments whose contents are not
+// TeX.
+var verbatimEnvironments = []string{"verbatim", "verbatim*", "Verbatim", "lstlisting", "minted", "comment"}
+
+// tex lexes the TeX command or math delimiter at the start of rest, if it is
+// one that opens or closes something, and returns its length.
+func (lx *lexer) tex(rest string) int {
+	switch {
+	case strings.IndexByte("()[]", rest[0]) >= 0:
+		// Only braces group in TeX: parentheses and square brackets are
+		// text, and half-open intervals such as [0, 1) are common.
+		lx.blank(rest[:1])
+		return 1
+	case rest[0] == '$':
+		size := 1
+		if strings.HasPrefix(rest, "$$") {
+			size = 2
+		}
+		lx.word(wordToggle, rest[:size], rest[:size], rest[:size])
+		return size
+	case strings.HasPrefix(rest, `\(`), strings.HasPrefix(rest, `\[`):
+		lx.word(wordOpen, rest[:2], `\`+string(ExpectedClose(rune(rest[1]))), rest[:2])
+		return 2
+	case strings.HasPrefix(rest, `\)`), strings.HasPrefix(rest, `\]`):
+		lx.word(wordClose, rest[:2], "", rest[:2])
+		return 2
+	}
+	name, size := texCommand(rest)
+	switch name {
+	case `\begin`, `\end`:
+		env, n := texArgument(rest[size:])
+		if env == "" {
+			return 0
+		}
+		size += n
+		if name == `\end` {
+			lx.word(wordClose, `\end{`+env+`}`, "", rest[:size])
+			return size
+		}
+		lx.word(wordOpen, `\begin{`+env+`}`, `\end{`+env+`}`, rest[:size])
+		if contains(verbatimEnvironments, env) {
+			lx.push(lexFrame{close: `\end{` + env + `}`, raw: true, multiline: true})
+		}
+		return size
+	case `\left`, `\right`:
+		// The delimiter that follows is part of the command: \left[ may
+		// well end with \right).
+		size += texDelimiter(rest[size:])
+		if name == `\left` {
+			lx.word(wordOpen, `\left`, `\right`, rest[:size])
+		} else {
+			lx.word(wordClose, `\right`, "", rest[:size])
+		}
+		return size
+	case `\verb`, `\verb*`:
+		if size < len(rest) && !isLetter(rest[size]) && rest[size] != ' ' {
+			lx.push(lexFrame{close: rest[size : size+1]})
+			lx.blank(rest[:size+1])
+			return size + 1
+		}
+	}
+	return 0
+}
+
+// word blanks text, which the lexer makes a bracket called name of.
+func (lx *lexer) word(kind tagKind, name, close, text string) {
+	lx.res.tags = append(lx.res.tags, tagToken{kind: kind, name: name, close: close, col: lx.col})
+	lx.blank(text)
+}
+
+// texCommand returns the control word at the start of s, backslash and
+// trailing star included, along with its length.
+func texCommand(s string) (string, int) {
+	if len(s) < 2 || s[0] != '\\' || !isLetter(s[1]) {
+		return "", 0
+	}
+	n := 2
+	for n < len(s) && isLetter(s[n]) {
+		n++
+	}
+	if n < len(s) && s[n] == '*' {
+		n++
+	}
+	return s[:n], n
+}
+
+// texArgument returns the braced argument at the start of s, spaces
+// before it included in the length.
+func texArgument(s string) (string, int) {
+	n := len(s) - len(strings.TrimLeft(s, " \t"))
+	if n >= len(s) || s[n] != '{' {
+		return "", 0
+	}
+	end := strings.IndexByte(s[n:], '}')
+	if end < 0 || strings.ContainsAny(s[n+1:n+end], "{\\") {
+		return "", 0
+	}
+	return s[n+1 : n+end], n + end + 1
+}
+
+// texDelimiter returns the length of the delimiter at the start of s, which
+// is a single character or a control sequence such as \{ or \langle.
+func texDelimiter(s string) int {
+	n := len(s) - len(strings.TrimLeft(s, " \t"))
+	if n >= len(s) {
+		return n
+	}
+	if name, size := texCommand(s[n:]); name != "" {
+		return n + size
+	}
+	if s[n] == '\\' && n+1 < len(s) {
+		_, size := utf8.DecodeRuneInString(s[n+1:])
+		return n + 1 + size
+	}
+	_, size := utf8.DecodeRuneInString(s[n:])
+	return n + size
+}
+
+// toggle opens b, or closes it when it is open in the current group, as the
+// $ that both starts and ends math in TeX.
+func (p *BracketParser) toggle(b Bracket) {
+	for i := len(p.stack) - 1; i >= 0 && p.stack[i].Kind != BracketOpenBrace; i-- {
+		if p.stack[i].Name == b.Name {
+			b.Close = ""
+			_ = p.closeBracket(b)
+			return
+		}
+	}
+	p.Push(b)
+}
@@ -0,0 +1,159 @@
+// Copyright (C) 2020 Fabio Del Vigna
+//
+// This file is part of drbracket.
+//
+// drbracket is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// drbracket is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with drbracket.  If not, see <http://www.gnu.org/licenses/>.
+
+package report
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/yoskini/drbracket/lib/baseline"
+	"github.com/yoskini/drbracket/lib/parser"
+)
+
+// GitHub prints workflow commands that GitHub Actions turns into annotations.
+type GitHub struct {
+	w   io.Writer
+	cwd string
+}
+
+func NewGitHub(w io.Writer, cwd string) *GitHub {
+	return &GitHub{
+		w:   w,
+		cwd: cwd,
+	}
+}
+
+func (g *GitHub) Report(f *File) error {
+	name := filepath.ToSlash(relativePath(g.cwd, f.Name))
+	for _, d := range f.Diagnostics {
+		position := fmt.Sprintf(",line=%d,col=%d", d.Line, d.Col)
+		if f.Cell > 0 {
+			position = ""
+		}
+		_, err := fmt.Fprintf(g.w, "::%s file=%s%s,title=%s::%s\n", d.Severity, escapeProperty(name), position,
+			escapeProperty("drbracket "+string(d.Kind)), escapeData(f.message(d)))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (g *GitHub) Finish() error {
+	return nil
+}
+
+var (
+	dataEscaper     = strings.NewReplacer("%", "%25", "\r", "%0D", "\n", "%0A")
+	propertyEscaper = strings.NewReplacer("%", "%25", "\r", "%0D", "\n", "%0A", ":", "%3A", ",", "%2C")
+)
+
+func escapeData(s string) string {
+	return dataEscaper.Replace(s)
+}
+
+func escapeProperty(s string) string {
+	return propertyEscaper.Replace(s)
+}
+
+type codeQualityIssue struct {
+	Description string              `json:"description"`
+	CheckName   string              `json:"check_name"`
+	Fingerprint string              `json:"fingerprint"`
+	Severity    string              `json:"severity"`
+	Location    codeQualityLocation `json:"location"`
+}
+
+type codeQualityLocation struct {
+	Path  string           `json:"path"`
+	Lines codeQualityLines `json:"lines"`
+}
+
+type codeQualityLines struct {
+	Begin int `json:"begin"`
+}
+
+// GitLab writes a GitLab Code Quality report. Fingerprints are derived from
+// the text around each problem, as for baselines, so that an issue keeps its
+// identity across commits that move it.
+type GitLab struct {
+	w      io.Writer
+	cwd    string
+	issues []codeQualityIssue
+}
+
+func NewGitLab(w io.Writer, cwd string) *GitLab {
+	return &GitLab{
+		w:      w,
+		cwd:    cwd,
+		issues: make([]codeQualityIssue, 0),
+	}
+}
+
+func (g *GitLab) Report(f *File) error {
+	name := filepath.ToSlash(relativePath(g.cwd, f.Name))
+	seen := make(map[string]int)
+	for _, d := range f.Diagnostics {
+		// Identical problems in the same context get distinct fingerprints
+		// by their order of occurrence.
+		fp := baseline.Fingerprint(f.Lines, d)
+		seen[fp]++
+		sum := md5.Sum([]byte(fmt.Sprintf("%s\x00%s\x00%d", f.unit(name), fp, seen[fp])))
+		severity := "major"
+		if d.Severity == parser.SeverityWarning {
+			severity = "minor"
+		}
+		line := d.Line
+		if f.Cell > 0 {
+			line = 1
+		}
+		g.issues = append(g.issues, codeQualityIssue{
+			Description: f.message(d),
+			CheckName:   "drbracket." + string(d.Kind),
+			Fingerprint: hex.EncodeToString(sum[:]),
+			Severity:    severity,
+			Location: codeQualityLocation{
+				Path:  name,
+				Lines: codeQualityLines{Begin: line},
+			},
+		})
+	}
+	return nil
+}
+
+func (g *GitLab) Finish() error {
+	sort.SliceStable(g.issues, func(i, j int) bool {
+		a, b := g.issues[i].Location, g.issues[j].Location
+		if a.Path != b.Path {
+			return a.Path < b.Path
+		}
+		return a.Lines.Begin < b.Lines.Begin
+	})
+	data, err := json.MarshalIndent(g.issues, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(g.w, "%s\n", data)
+	return err
+}
@@ -0,0 +1,79 @@
+// Copyright (C) 2020 Fabio Del Vigna
+//
+// This file is part of drbracket.
+//
+// drbracket is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// drbracket is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with drbracket.  If not, see <http://www.gnu.org/licenses/>.
+
+package report
+
+import (
+	"fmt"
+	"io"
+	"path/filepath"
+
+	"github.com/yoskini/drbracket/lib/parser"
+)
+
+// GNU prints one "file:line:col: severity: message" line per diagnostic, as
+// understood by editors and compilation buffers.
+type GNU struct {
+	w   io.Writer
+	cwd string
+}
+
+// NewGNU returns a reporter that prints file names relative to cwd.
+func NewGNU(w io.Writer, cwd string) *GNU {
+	return &GNU{
+		w:   w,
+		cwd: cwd,
+	}
+}
+
+func (g *GNU) Report(f *File) error {
+	name := relativePath(g.cwd, f.Name)
+	for _, d := range f.Diagnostics {
+		if _, err := fmt.Fprintf(g.w, "%s: %s: %s\n", f.location(name, d.Line, d.Col), d.Severity, summary(d)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (g *GNU) Finish() error {
+	return nil
+}
+
+// summary is a one line message for d that does not repeat its position.
+func summary(d parser.Diagnostic) string {
+	title, _ := describe(d)
+	if d.Kind == parser.DiagnosticMismatched {
+		return fmt.Sprintf("%s to close %s from line %d, col %d", title, d.Open, d.Open.Line, d.Open.Col)
+	}
+	return title
+}
+
+// relativePath rewrites path relative to cwd. Names that are not paths, such
+// as <stdin>, are kept as they are.
+func relativePath(cwd, path string) string {
+	if cwd == "" || path == "" || path[0] == '<' {
+		return path
+	}
+	if !filepath.IsAbs(path) {
+		return filepath.Clean(path)
+	}
+	if rel, err := filepath.Rel(cwd, path); err == nil {
+		return rel
+	}
+	return path
+}
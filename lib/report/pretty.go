@@ -0,0 +1,277 @@
+// Copyright (C) 2020 Fabio Del Vigna
+//
+// This file is part of drbracket.
+//
+// drbracket is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// drbracket is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with drbracket.  If not, see <http://www.gnu.org/licenses/>.
+
+package report
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/yoskini/drbracket/lib/parser"
+)
+
+const (
+	ansiReset  = "\x1b[0m"
+	ansiBold   = "\x1b[1m"
+	ansiRed    = "\x1b[1;31m"
+	ansiYellow = "\x1b[1;33m"
+	ansiBlue   = "\x1b[1;34m"
+)
+
+const tabWidth = 4
+
+// label marks a column of a line, with '^' for the position of the
+// diagnostic and '-' for related positions such as the opener.
+type label struct {
+	line    int
+	col     int
+	primary bool
+	text    string
+}
+
+// Pretty renders diagnostics for a terminal, quoting the offending lines with
+// carets under the brackets involved.
+type Pretty struct {
+	w        io.Writer
+	color    bool
+	errors   int
+	warnings int
+	// files holds the names of the files with problems, of which notebooks
+	// are reported one cell at a time.
+	files map[string]bool
+}
+
+func NewPretty(w io.Writer, color bool) *Pretty {
+	return &Pretty{
+		w:     w,
+		color: color,
+		files: make(map[string]bool),
+	}
+}
+
+func (p *Pretty) Report(f *File) error {
+	if len(f.Diagnostics) > 0 {
+		p.files[f.Name] = true
+	}
+	for _, d := range f.Diagnostics {
+		if d.Severity == parser.SeverityWarning {
+			p.warnings++
+		} else {
+			p.errors++
+		}
+		if _, err := io.WriteString(p.w, p.render(f, d)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *Pretty) Finish() error {
+	if p.errors == 0 && p.warnings == 0 {
+		return nil
+	}
+	_, err := fmt.Fprintf(p.w, "%s in %s\n",
+		p.paint(ansiBold, plural(p.errors, "error")+", "+plural(p.warnings, "warning")), plural(len(p.files), "file"))
+	return err
+}
+
+func (p *Pretty) paint(style, s string) string {
+	if !p.color || s == "" {
+		return s
+	}
+	return style + s + ansiReset
+}
+
+func (p *Pretty) render(f *File, d parser.Diagnostic) string {
+	title, labels := describe(d)
+	style := ansiRed
+	if d.Severity == parser.SeverityWarning {
+		style = ansiYellow
+	}
+	lines := make([]int, 0, 2)
+	seen := make(map[int]bool)
+	for _, l := range labels {
+		if !seen[l.line] {
+			seen[l.line] = true
+			lines = append(lines, l.line)
+		}
+	}
+	sort.Ints(lines)
+	width := len(strconv.Itoa(lines[len(lines)-1]))
+	gutter := p.paint(ansiBlue, strings.Repeat(" ", width)+" |")
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "%s %s\n", p.paint(style, d.Severity.String()+"["+string(d.Kind)+"]:"), p.paint(ansiBold, title))
+	fmt.Fprintf(&sb, "%s %s\n", p.paint(ansiBlue, strings.Repeat(" ", width)+"-->"), f.location(f.Name, d.Line, d.Col))
+	sb.WriteString(gutter + "\n")
+	for i, n := range lines {
+		if i > 0 && n > lines[i-1]+1 {
+			sb.WriteString(p.paint(ansiBlue, strings.Repeat(".", width+2)) + "\n")
+		}
+		text := ""
+		if n >= 1 && n <= len(f.Lines) {
+			text = f.Lines[n-1]
+		}
+		fmt.Fprintf(&sb, "%s %s\n", p.paint(ansiBlue, fmt.Sprintf("%*d |", width, n)), expandTabs(text))
+		lineLabels := make([]label, 0, 2)
+		for _, l := range labels {
+			if l.line == n {
+				lineLabels = append(lineLabels, l)
+			}
+		}
+		for _, row := range p.markers(text, lineLabels, style) {
+			sb.WriteString(strings.TrimRight(gutter+" "+row, " ") + "\n")
+		}
+	}
+	sb.WriteString("\n")
+	return sb.String()
+}
+
+// markers draws the rows under a source line: one with a marker per label and
+// the text of the rightmost one, then the texts of the others, each hanging
+// from its marker.
+func (p *Pretty) markers(text string, labels []label, style string) []string {
+	sort.Slice(labels, func(i, j int) bool { return labels[i].col < labels[j].col })
+	cols := make([]int, len(labels))
+	for i, l := range labels {
+		cols[i] = displayCol(text, l.col)
+	}
+	paint := func(l label, s string) string {
+		if l.primary {
+			return p.paint(style, s)
+		}
+		return p.paint(ansiBlue, s)
+	}
+	row := func(upto int, last string) string {
+		var sb strings.Builder
+		pos := 0
+		for i := 0; i < upto; i++ {
+			sb.WriteString(strings.Repeat(" ", cols[i]-pos))
+			sb.WriteString(paint(labels[i], "|"))
+			pos = cols[i] + 1
+		}
+		if upto < len(labels) {
+			sb.WriteString(strings.Repeat(" ", cols[upto]-pos))
+			sb.WriteString(last)
+		}
+		return sb.String()
+	}
+
+	rows := make([]string, 0, 2*len(labels))
+	var sb strings.Builder
+	pos := 0
+	for i, l := range labels {
+		sb.WriteString(strings.Repeat(" ", cols[i]-pos))
+		marker := "-"
+		if l.primary {
+			marker = "^"
+		}
+		sb.WriteString(paint(l, marker))
+		pos = cols[i] + 1
+	}
+	last := labels[len(labels)-1]
+	if last.text != "" {
+		sb.WriteString(" " + paint(last, last.text))
+	}
+	rows = append(rows, sb.String())
+	for i := len(labels) - 2; i >= 0; i-- {
+		if labels[i].text == "" {
+			continue
+		}
+		rows = append(rows, row(i+1, ""))
+		rows = append(rows, row(i, paint(labels[i], labels[i].text)))
+	}
+	return rows
+}
+
+// describe returns a short title for d and the labels placed on its source.
+func describe(d parser.Diagnostic) (string, []label) {
+	switch d.Kind {
+	case parser.DiagnosticMismatched:
+		return fmt.Sprintf("Unbalanced %s. Found %s, expected %s", d.Open.Noun(), d.Found, d.Open.Closer()), []label{
+			{line: d.Open.Line, col: d.Open.Col, text: fmt.Sprintf("%s opened here", d.Open)},
+			{line: d.Line, col: d.Col, primary: true, text: "found " + d.Found},
+		}
+	case parser.DiagnosticUnexpected:
+		return fmt.Sprintf("Unexpected %s", d.Found), []label{
+			{line: d.Line, col: d.Col, primary: true, text: "no bracket is open"},
+		}
+	case parser.DiagnosticUnclosed:
+		return fmt.Sprintf("Unclosed %s %s", d.Open, d.Open.Noun()), []label{
+			{line: d.Line, col: d.Col, primary: true, text: "never closed"},
+		}
+	case parser.DiagnosticUnclosedForm:
+		return fmt.Sprintf("Unclosed form %s", d.Form), []label{
+			{line: d.Line, col: d.Col, primary: true, text: "never closed"},
+		}
+	case parser.DiagnosticUnterminatedInterpolation:
+		return fmt.Sprintf("Unterminated interpolation %s", d.Found), []label{
+			{line: d.Line, col: d.Col, primary: true, text: "never closed"},
+		}
+	case parser.DiagnosticUnclosedComment, parser.DiagnosticUnclosedString:
+		noun := "comment"
+		if d.Kind == parser.DiagnosticUnclosedString {
+			noun = "string"
+		}
+		return fmt.Sprintf("Unclosed %s %s", noun, d.Found), []label{
+			{line: d.Line, col: d.Col, primary: true, text: "never closed"},
+		}
+	case parser.DiagnosticUnusedSuppression:
+		return fmt.Sprintf("Unused %s suppression", d.Found), []label{
+			{line: d.Line, col: d.Col, primary: true, text: "suppresses nothing"},
+		}
+	case parser.DiagnosticInvalidEncoding:
+		return fmt.Sprintf("Invalid %s sequence", d.Found), []label{
+			{line: d.Line, col: d.Col, primary: true, text: "replaced by U+FFFD"},
+		}
+	}
+	return d.Message, []label{{line: d.Line, col: d.Col, primary: true}}
+}
+
+func expandTabs(s string) string {
+	return strings.ReplaceAll(s, "\t", strings.Repeat(" ", tabWidth))
+}
+
+// displayCol converts a 1-based rune column to the 0-based screen column of
+// the line as printed by expandTabs.
+func displayCol(line string, col int) int {
+	pos := 0
+	for i, r := range []rune(line) {
+		if i >= col-1 {
+			break
+		}
+		if r == '\t' {
+			pos += tabWidth
+		} else {
+			pos++
+		}
+	}
+	if n := len([]rune(line)); col-1 > n {
+		pos += col - 1 - n
+	}
+	return pos
+}
+
+func plural(n int, word string) string {
+	if n == 1 {
+		return fmt.Sprintf("%d %s", n, word)
+	}
+	return fmt.Sprintf("%d %ss", n, word)
+}
@@ -0,0 +1,97 @@
+// Copyright (C) 2020 Fabio Del Vigna
+//
+// This file is part of drbracket.
+//
+// drbracket is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// drbracket is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with drbracket.  If not, see <http://www.gnu.org/licenses/>.
+
+package report
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/yoskini/drbracket/lib/parser"
+)
+
+const (
+	ColorAuto   = "auto"
+	ColorAlways = "always"
+	ColorNever  = "never"
+)
+
+// File holds the diagnostics of a checked source along with its lines, which
+// some formats quote. Cell is the index of the notebook cell that the lines
+// come from, 0 for other sources.
+type File struct {
+	Name        string
+	Cell        int
+	Lines       []string
+	Diagnostics []parser.Diagnostic
+}
+
+// unit names the part of the source named name that f holds.
+func (f *File) unit(name string) string {
+	if f.Cell > 0 {
+		return fmt.Sprintf("%s:cell %d", name, f.Cell)
+	}
+	return name
+}
+
+// location formats a position in f, as name:line:col or, in a notebook
+// cell, as name:cell 7:line 3:col 5.
+func (f *File) location(name string, line, col int) string {
+	if f.Cell > 0 {
+		return fmt.Sprintf("%s:line %d:col %d", f.unit(name), line, col)
+	}
+	return fmt.Sprintf("%s:%d:%d", name, line, col)
+}
+
+// message returns the summary of d for the formats that locate problems by
+// line in the file itself, which cannot point into a notebook cell: the
+// position in the cell goes into the message instead.
+func (f *File) message(d parser.Diagnostic) string {
+	if f.Cell > 0 {
+		return fmt.Sprintf("cell %d:line %d:col %d: %s", f.Cell, d.Line, d.Col, summary(d))
+	}
+	return summary(d)
+}
+
+// Reporter prints diagnostics in a given format. Report is called once per
+// checked file and Finish once all files are checked.
+type Reporter interface {
+	Report(f *File) error
+	Finish() error
+}
+
+// UseColor tells whether output written to out should be coloured. With auto
+// it is when out is a terminal and NO_COLOR is not set.
+func UseColor(mode string, out *os.File) (bool, error) {
+	switch mode {
+	case ColorAlways:
+		return true, nil
+	case ColorNever:
+		return false, nil
+	case ColorAuto:
+		if os.Getenv("NO_COLOR") != "" {
+			return false, nil
+		}
+		return IsTerminal(out), nil
+	}
+	return false, fmt.Errorf("Unknown color mode %s", mode)
+}
+
+func IsTerminal(f *os.File) bool {
+	stat, err := f.Stat()
+	return err == nil && stat.Mode()&os.ModeCharDevice != 0
+}
@@ -0,0 +1,170 @@
+// Copyright (C) 2020 Fabio Del Vigna
+//
+// This file is part of drbracket.
+//
+// drbracket is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// drbracket is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with drbracket.  If not, see <http://www.gnu.org/licenses/>.
+
+package report
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/yoskini/drbracket/lib/parser"
+)
+
+type junitSuites struct {
+	XMLName  xml.Name     `xml:"testsuites"`
+	Tests    int          `xml:"tests,attr"`
+	Failures int          `xml:"failures,attr"`
+	Suites   []junitSuite `xml:"testsuite"`
+}
+
+type junitSuite struct {
+	Name     string      `xml:"name,attr"`
+	Tests    int         `xml:"tests,attr"`
+	Failures int         `xml:"failures,attr"`
+	Errors   int         `xml:"errors,attr"`
+	Cases    []junitCase `xml:"testcase"`
+}
+
+type junitCase struct {
+	Name      string         `xml:"name,attr"`
+	Classname string         `xml:"classname,attr"`
+	Failures  []junitFailure `xml:"failure"`
+	SystemOut string         `xml:"system-out,omitempty"`
+}
+
+type junitFailure struct {
+	Message string `xml:"message,attr"`
+	Type    string `xml:"type,attr"`
+	Text    string `xml:",chardata"`
+}
+
+// JUnit writes a JUnit XML report with a testcase per checked file and a
+// failure per error. Warnings go to the system-out of the testcase.
+type JUnit struct {
+	w     io.Writer
+	cwd   string
+	suite junitSuite
+}
+
+func NewJUnit(w io.Writer, cwd string) *JUnit {
+	return &JUnit{
+		w:     w,
+		cwd:   cwd,
+		suite: junitSuite{Name: "drbracket"},
+	}
+}
+
+func (j *JUnit) Report(f *File) error {
+	name := relativePath(j.cwd, f.Name)
+	tc := junitCase{Name: f.unit(name), Classname: "drbracket"}
+	var out strings.Builder
+	for _, d := range f.Diagnostics {
+		text := fmt.Sprintf("%s: %s", f.location(name, d.Line, d.Col), summary(d))
+		if d.Severity == parser.SeverityWarning {
+			fmt.Fprintf(&out, "%s: %s\n", d.Severity, text)
+			continue
+		}
+		tc.Failures = append(tc.Failures, junitFailure{Message: summary(d), Type: string(d.Kind), Text: text})
+	}
+	tc.SystemOut = out.String()
+	j.suite.Tests++
+	j.suite.Failures += len(tc.Failures)
+	j.suite.Cases = append(j.suite.Cases, tc)
+	return nil
+}
+
+func (j *JUnit) Finish() error {
+	sort.SliceStable(j.suite.Cases, func(a, b int) bool { return j.suite.Cases[a].Name < j.suite.Cases[b].Name })
+	return writeXML(j.w, junitSuites{
+		Tests:    j.suite.Tests,
+		Failures: j.suite.Failures,
+		Suites:   []junitSuite{j.suite},
+	})
+}
+
+type checkstyleReport struct {
+	XMLName xml.Name         `xml:"checkstyle"`
+	Version string           `xml:"version,attr"`
+	Files   []checkstyleFile `xml:"file"`
+}
+
+type checkstyleFile struct {
+	Name   string            `xml:"name,attr"`
+	Errors []checkstyleError `xml:"error"`
+}
+
+type checkstyleError struct {
+	Line     int    `xml:"line,attr,omitempty"`
+	Column   int    `xml:"column,attr,omitempty"`
+	Severity string `xml:"severity,attr"`
+	Message  string `xml:"message,attr"`
+	Source   string `xml:"source,attr"`
+}
+
+// Checkstyle writes a Checkstyle XML report listing every checked file.
+type Checkstyle struct {
+	w      io.Writer
+	cwd    string
+	report checkstyleReport
+}
+
+func NewCheckstyle(w io.Writer, cwd string) *Checkstyle {
+	return &Checkstyle{
+		w:      w,
+		cwd:    cwd,
+		report: checkstyleReport{Version: "4.3"},
+	}
+}
+
+func (c *Checkstyle) Report(f *File) error {
+	file := checkstyleFile{Name: relativePath(c.cwd, f.Name)}
+	for _, d := range f.Diagnostics {
+		e := checkstyleError{
+			Severity: d.Severity.String(),
+			Message:  f.message(d),
+			Source:   "drbracket." + string(d.Kind),
+		}
+		if f.Cell == 0 {
+			e.Line, e.Column = d.Line, d.Col
+		}
+		file.Errors = append(file.Errors, e)
+	}
+	// The cells of a notebook go into the entry of the notebook.
+	if n := len(c.report.Files); f.Cell > 0 && n > 0 && c.report.Files[n-1].Name == file.Name {
+		c.report.Files[n-1].Errors = append(c.report.Files[n-1].Errors, file.Errors...)
+		return nil
+	}
+	c.report.Files = append(c.report.Files, file)
+	return nil
+}
+
+func (c *Checkstyle) Finish() error {
+	sort.SliceStable(c.report.Files, func(a, b int) bool { return c.report.Files[a].Name < c.report.Files[b].Name })
+	return writeXML(c.w, c.report)
+}
+
+func writeXML(w io.Writer, v interface{}) error {
+	data, err := xml.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "%s%s\n", xml.Header, data)
+	return err
+}
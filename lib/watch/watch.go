@@ -0,0 +1,71 @@
+// Copyright (C) 2020 Fabio Del Vigna
+//
+// This file is part of drbracket.
+//
+// drbracket is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// drbracket is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with drbracket.  If not, see <http://www.gnu.org/licenses/>.
+
+package watch
+
+import (
+	"sort"
+	"time"
+)
+
+// debouncer collects the paths reported by the platform watcher and hands
+// them out in batches, once no new change has arrived for the delay.
+type debouncer struct {
+	in     chan string
+	out    chan []string
+	delay  time.Duration
+	closed chan struct{}
+}
+
+func newDebouncer(delay time.Duration) *debouncer {
+	d := &debouncer{
+		in:     make(chan string, 100),
+		out:    make(chan []string),
+		delay:  delay,
+		closed: make(chan struct{}),
+	}
+	go d.run()
+	return d
+}
+
+func (d *debouncer) run() {
+	defer close(d.out)
+	pending := make(map[string]bool)
+	timer := time.NewTimer(d.delay)
+	timer.Stop()
+	for {
+		select {
+		case p := <-d.in:
+			pending[p] = true
+			timer.Reset(d.delay)
+		case <-timer.C:
+			batch := make([]string, 0, len(pending))
+			for p := range pending {
+				batch = append(batch, p)
+			}
+			sort.Strings(batch)
+			pending = make(map[string]bool)
+			select {
+			case d.out <- batch:
+			case <-d.closed:
+				return
+			}
+		case <-d.closed:
+			return
+		}
+	}
+}
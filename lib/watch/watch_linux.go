@@ -0,0 +1,163 @@
+// Copyright (C) 2020 Fabio Del Vigna
+//
+// This file is part of drbracket.
+//
+// drbracket is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// drbracket is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with drbracket.  If not, see <http://www.gnu.org/licenses/>.
+
+package watch
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+const dirEvents = unix.IN_CLOSE_WRITE | unix.IN_MOVED_TO | unix.IN_MOVED_FROM | unix.IN_CREATE | unix.IN_DELETE | unix.IN_DELETE_SELF | unix.IN_ONLYDIR
+
+type watchedDir struct {
+	path      string
+	recursive bool
+}
+
+// Watcher reports the files written, moved or removed in the watched
+// directories. Recursive watches also cover directories created later.
+type Watcher struct {
+	Changes <-chan []string
+	Errors  <-chan error
+
+	file     *os.File
+	mu       sync.Mutex
+	dirs     map[int]watchedDir
+	debounce *debouncer
+	errors   chan error
+}
+
+func New(delay time.Duration) (*Watcher, error) {
+	fd, err := unix.InotifyInit1(unix.IN_CLOEXEC | unix.IN_NONBLOCK)
+	if err != nil {
+		return nil, fmt.Errorf("Cannot initialize inotify: %s", err)
+	}
+	w := &Watcher{
+		file:     os.NewFile(uintptr(fd), "inotify"),
+		dirs:     make(map[int]watchedDir),
+		debounce: newDebouncer(delay),
+		errors:   make(chan error, 10),
+	}
+	w.Changes = w.debounce.out
+	w.Errors = w.errors
+	go w.read()
+	return w, nil
+}
+
+// Add watches dir and, when recursive, all the directories below it.
+func (w *Watcher) Add(dir string, recursive bool) error {
+	if !recursive {
+		return w.addWatch(dir, false)
+	}
+	return filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return fmt.Errorf("Cannot explore path %s: %s", path, err)
+		}
+		if !d.IsDir() {
+			return nil
+		}
+		return w.addWatch(path, true)
+	})
+}
+
+func (w *Watcher) addWatch(dir string, recursive bool) error {
+	wd, err := unix.InotifyAddWatch(int(w.file.Fd()), dir, dirEvents)
+	if err != nil {
+		return fmt.Errorf("Cannot watch %s: %s", dir, err)
+	}
+	w.mu.Lock()
+	w.dirs[wd] = watchedDir{path: dir, recursive: recursive}
+	w.mu.Unlock()
+	return nil
+}
+
+func (w *Watcher) Close() error {
+	close(w.debounce.closed)
+	return w.file.Close()
+}
+
+func (w *Watcher) read() {
+	buf := make([]byte, 64*(unix.SizeofInotifyEvent+unix.PathMax))
+	for {
+		n, err := w.file.Read(buf)
+		if err != nil {
+			if !errors.Is(err, os.ErrClosed) {
+				w.report(fmt.Errorf("Cannot read inotify events: %s", err))
+			}
+			return
+		}
+		for off := 0; off+unix.SizeofInotifyEvent <= n; {
+			ev := (*unix.InotifyEvent)(unsafe.Pointer(&buf[off]))
+			name := buf[off+unix.SizeofInotifyEvent : off+unix.SizeofInotifyEvent+int(ev.Len)]
+			off += unix.SizeofInotifyEvent + int(ev.Len)
+			w.handle(ev, string(bytes.TrimRight(name, "\x00")))
+		}
+	}
+}
+
+func (w *Watcher) handle(ev *unix.InotifyEvent, name string) {
+	if ev.Mask&unix.IN_Q_OVERFLOW != 0 {
+		w.report(fmt.Errorf("Too many changes, some were lost"))
+		return
+	}
+	w.mu.Lock()
+	dir, ok := w.dirs[int(ev.Wd)]
+	if ev.Mask&(unix.IN_DELETE_SELF|unix.IN_IGNORED) != 0 {
+		delete(w.dirs, int(ev.Wd))
+	}
+	w.mu.Unlock()
+	if !ok || name == "" {
+		return
+	}
+	path := filepath.Join(dir.path, name)
+	switch {
+	case ev.Mask&unix.IN_ISDIR != 0:
+		if !dir.recursive || ev.Mask&(unix.IN_CREATE|unix.IN_MOVED_TO) == 0 {
+			return
+		}
+		// Files may land in a new directory before it is watched, so report
+		// what it already holds.
+		if err := w.Add(path, true); err != nil {
+			w.report(err)
+		}
+		_ = filepath.WalkDir(path, func(p string, d fs.DirEntry, err error) error {
+			if err == nil && d.Type().IsRegular() {
+				w.debounce.in <- p
+			}
+			return nil
+		})
+	case ev.Mask&(unix.IN_CLOSE_WRITE|unix.IN_MOVED_TO|unix.IN_MOVED_FROM|unix.IN_DELETE) != 0:
+		w.debounce.in <- path
+	}
+}
+
+func (w *Watcher) report(err error) {
+	select {
+	case w.errors <- err:
+	default:
+	}
+}
@@ -0,0 +1,42 @@
+// Copyright (C) 2020 Fabio Del Vigna
+//
+// This file is part of drbracket.
+//
+// drbracket is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// drbracket is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with drbracket.  If not, see <http://www.gnu.org/licenses/>.
+
+//go:build !linux
+
+package watch
+
+import (
+	"fmt"
+	"time"
+)
+
+type Watcher struct {
+	Changes <-chan []string
+	Errors  <-chan error
+}
+
+func New(delay time.Duration) (*Watcher, error) {
+	return nil, fmt.Errorf("Watch mode is only supported on Linux")
+}
+
+func (w *Watcher) Add(dir string, recursive bool) error {
+	return nil
+}
+
+func (w *Watcher) Close() error {
+	return nil
+}
@@ -18,52 +18,99 @@
 package main
 
 import (
-	"bufio"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
 
 	"sync"
 
 	flags "github.com/jessevdk/go-flags"
 	"github.com/sirupsen/logrus"
+	"github.com/yoskini/drbracket/lib/charset"
+	"github.com/yoskini/drbracket/lib/git"
+	"github.com/yoskini/drbracket/lib/lsp"
 	"github.com/yoskini/drbracket/lib/parser"
+	"github.com/yoskini/drbracket/lib/report"
 )
 
 func HasCodeExtension(filename string) bool {
 	extensions := map[string]bool{
-		"ada":   true,
-		"adb":   true,
-		"2.ada": true,
-		"bas":   true,
-		"c":     true,
-		"clj":   true,
-		"cls":   true,
-		"cpp":   true,
-		"cc":    true,
-		"cxx":   true,
-		"cbp":   true,
-		"cs":    true,
-		"d":     true,
-		"for":   true,
-		"ftn":   true,
-		"f90":   true,
-		"go":    true,
-		"hpp":   true,
-		"hxx":   true,
-		"hs":    true,
-		"java":  true,
-		"lisp":  true,
-		"m":     true,
-		"php":   true,
-		"py":    true,
-		"r":     true,
-		"rb":    true,
-		"scala": true,
-		"sci":   true,
-		"sh":    true,
-		"conf":  true,
+		"ada":        true,
+		"adb":        true,
+		"2.ada":      true,
+		"bas":        true,
+		"c":          true,
+		"clj":        true,
+		"cljs":       true,
+		"cljc":       true,
+		"edn":        true,
+		"scm":        true,
+		"cls":        true,
+		"cpp":        true,
+		"cc":         true,
+		"cxx":        true,
+		"cbp":        true,
+		"cs":         true,
+		"d":          true,
+		"for":        true,
+		"ftn":        true,
+		"f90":        true,
+		"go":         true,
+		"hpp":        true,
+		"hxx":        true,
+		"hs":         true,
+		"java":       true,
+		"js":         true,
+		"kt":         true,
+		"kts":        true,
+		"lisp":       true,
+		"mjs":        true,
+		"cjs":        true,
+		"m":          true,
+		"php":        true,
+		"py":         true,
+		"r":          true,
+		"rs":         true,
+		"rb":         true,
+		"scala":      true,
+		"sci":        true,
+		"swift":      true,
+		"ts":         true,
+		"sh":         true,
+		"bash":       true,
+		"conf":       true,
+		"html":       true,
+		"htm":        true,
+		"xml":        true,
+		"svg":        true,
+		"xsd":        true,
+		"xsl":        true,
+		"xslt":       true,
+		"xhtml":      true,
+		"jsx":        true,
+		"tsx":        true,
+		"css":        true,
+		"vue":        true,
+		"svelte":     true,
+		"phtml":      true,
+		"md":         true,
+		"markdown":   true,
+		"ipynb":      true,
+		"tex":        true,
+		"sty":        true,
+		"ltx":        true,
+		"tmpl":       true,
+		"gotmpl":     true,
+		"gohtml":     true,
+		"j2":         true,
+		"jinja":      true,
+		"jinja2":     true,
+		"hbs":        true,
+		"handlebars": true,
+		"mustache":   true,
 	}
 	tokens := strings.Split(filename, ".")
 	ext := tokens[len(tokens)-1]
@@ -73,108 +120,400 @@ func HasCodeExtension(filename string) bool {
 	return false
 }
 
-func walker(p string, files chan<- string) error {
+type fileID struct {
+	dev  uint64
+	ino  uint64
+	path string
+}
+
+func absPath(path string) string {
+	if resolved, err := filepath.EvalSymlinks(path); err == nil {
+		path = resolved
+	}
+	if abs, err := filepath.Abs(path); err == nil {
+		return abs
+	}
+	return filepath.Clean(path)
+}
+
+// visitedSet is shared by all walkers so that a file reached through several
+// paths, or a directory reached again through a symlink, is handled once.
+type visitedSet struct {
+	mu   sync.Mutex
+	seen map[fileID]bool
+}
+
+func newVisitedSet() *visitedSet {
+	return &visitedSet{
+		seen: make(map[fileID]bool),
+	}
+}
+
+func (v *visitedSet) firstVisit(path string, info os.FileInfo) bool {
+	id := getFileID(path, info)
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if v.seen[id] {
+		return false
+	}
+	v.seen[id] = true
+	return true
+}
+
+func walker(p string, files chan<- string, visited *visitedSet) error {
+	if p == stdinPath {
+		files <- p
+		return nil
+	}
 	stat, err := os.Stat(p)
 	if err != nil {
 		return fmt.Errorf("Cannot stat file %s: %s", p, err)
 	}
 	switch mode := stat.Mode(); {
 	case mode.IsDir():
-		err := filepath.Walk(p, func(path string, info os.FileInfo, err error) error {
-			if err != nil {
-				return fmt.Errorf("Cannot explore path %s: %s", path, err)
-			}
-			stat, err := os.Stat(path)
-			if err != nil {
-				return fmt.Errorf("Cannot stat file %s: %s", path, err)
-			}
-			if stat.Mode().IsRegular() {
-				if HasCodeExtension(path) {
-					files <- path
-				}
-			}
+		if !visited.firstVisit(p, stat) {
 			return nil
-		})
+		}
+		err := walkDir(p, getFileID(p, stat).dev, files, visited)
 		if err != nil {
 			return fmt.Errorf("Cannot walk filepath %s: %s", p, err)
 		}
 	case mode.IsRegular():
-		if HasCodeExtension(p) {
+		if HasCodeExtension(p) && visited.firstVisit(p, stat) {
 			files <- p
 		}
 	}
 	return nil
 }
 
-func tester(c <-chan string) error {
-	for f := range c {
-		fh, err := os.Open(f)
+func walkDir(dir string, rootDev uint64, files chan<- string, visited *visitedSet) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return fmt.Errorf("Cannot explore path %s: %s", dir, err)
+	}
+	for _, entry := range entries {
+		path := filepath.Join(dir, entry.Name())
+		stat, err := os.Lstat(path)
 		if err != nil {
-			return fmt.Errorf("Cannot open file %s", f)
-		}
-		defer fh.Close()
-		scanner := bufio.NewScanner(fh)
-		lineNum := 0
-		parser := parser.NewBracketParser()
-		if parser == nil {
-			fh.Close()
-			return fmt.Errorf("Cannot instantiate BracketParser")
-		}
-		for scanner.Scan() {
-			lineNum++
-			line := scanner.Text()
-			tstring := strings.TrimSpace(line)
-			switch {
-			case strings.HasPrefix(tstring, "//"):
-				fallthrough
-			case strings.HasPrefix(tstring, "--"):
-				fallthrough
-			case strings.HasPrefix(tstring, "#"):
-				fallthrough
-			case strings.HasPrefix(tstring, "/*"):
-				fallthrough
-			case strings.HasPrefix(tstring, "<!--"):
-				fallthrough
-			case strings.HasPrefix(tstring, "!*"):
-				fallthrough
-			case strings.HasPrefix(tstring, "{-"):
-				fallthrough
-			case strings.HasPrefix(tstring, "%"):
-				fallthrough
-			case strings.HasPrefix(tstring, "\"\"\""):
+			return fmt.Errorf("Cannot stat file %s: %s", path, err)
+		}
+		// Symlinked files are always checked, symlinked directories are
+		// only walked with --follow-symlinks.
+		if stat.Mode()&os.ModeSymlink != 0 {
+			stat, err = os.Stat(path)
+			if err != nil {
+				logrus.Warnf("Skipping broken symlink %s", path)
+				continue
+			}
+			if stat.IsDir() && !config.FollowSymlinks {
+				logrus.Debugf("Skipping symlinked directory %s", path)
 				continue
-			default:
 			}
-			err := parser.ParseLine(lineNum, line)
+		}
+		if config.OneFileSystem && getFileID(path, stat).dev != rootDev {
+			continue
+		}
+		switch mode := stat.Mode(); {
+		case mode.IsDir():
+			if visited.firstVisit(path, stat) {
+				if err := walkDir(path, rootDev, files, visited); err != nil {
+					return err
+				}
+			}
+		case mode.IsRegular():
+			if HasCodeExtension(path) && visited.firstVisit(path, stat) {
+				files <- path
+			}
+		}
+	}
+	return nil
+}
+
+// sourceName is the name used to label diagnostics and to pick the language
+// of a source, which for stdin is whatever --stdin-filename says.
+func sourceName(f string) string {
+	if f == stdinPath {
+		if config.StdinFilename != "" {
+			return config.StdinFilename
+		}
+		return "<stdin>"
+	}
+	return f
+}
+
+func readInput(f string) ([]byte, error) {
+	if f == stdinPath {
+		return io.ReadAll(os.Stdin)
+	}
+	return os.ReadFile(f)
+}
+
+// readSource reads and decodes f, returning a warning for every invalid
+// sequence found in it.
+func readSource(f string) (string, []parser.Diagnostic, error) {
+	name := sourceName(f)
+	var data []byte
+	var err error
+	if c, ok := staged.changes[f]; ok {
+		// Staged changes are judged by what would be committed.
+		data, err = staged.repo.Show("", c.Path)
+	} else {
+		data, err = readInput(f)
+	}
+	if err != nil {
+		return "", nil, fmt.Errorf("Cannot open file %s", name)
+	}
+	text, err := decodeSource(data)
+	if err != nil {
+		return "", nil, fmt.Errorf("Cannot decode file %s: %s", name, err)
+	}
+	invalid := make([]parser.Diagnostic, 0, len(text.Invalid))
+	for _, pos := range text.Invalid {
+		invalid = append(invalid, parser.EncodingError(text.Encoding, pos.Line, pos.Col))
+	}
+	return text.Text, invalid, nil
+}
+
+func decodeSource(data []byte) (*charset.Text, error) {
+	return charset.Decode(data, config.Encoding)
+}
+
+// readFileList reads the paths listed in f, one per line or NUL-separated as
+// produced by `git ls-files -z` and `find -print0`.
+func readFileList(f string, null bool) ([]string, error) {
+	data, err := readInput(f)
+	if err != nil {
+		return nil, fmt.Errorf("Cannot read file list %s: %s", f, err)
+	}
+	sep := "\n"
+	if null {
+		sep = "\x00"
+	}
+	paths := make([]string, 0)
+	for _, p := range strings.Split(string(data), sep) {
+		if !null {
+			p = strings.TrimSuffix(p, "\r")
+		}
+		if p != "" {
+			paths = append(paths, p)
+		}
+	}
+	return paths, nil
+}
+
+// unit is a part of a source that is checked on its own: the whole source,
+// or a code cell of a notebook.
+type unit struct {
+	cell        int
+	lines       []string
+	diagnostics []parser.Diagnostic
+}
+
+// checkText runs the bracket parser over text, using the language rules that
+// match name, and returns its lines together with the diagnostics found.
+// Notebooks are checked cell by cell.
+func checkText(name, text string) ([]unit, error) {
+	if isNotebook(name) {
+		return checkNotebook(text)
+	}
+	lines, diagnostics := checkDocument(parser.LanguageForFile(name), text)
+	return []unit{{lines: lines, diagnostics: diagnostics}}, nil
+}
+
+func checkDocument(lang *parser.Language, text string) ([]string, []parser.Diagnostic) {
+	doc := parser.NewDocument(lang, text)
+	diagnostics := doc.Diagnostics()
+	if config.ReportUnusedSuppressions {
+		diagnostics = append(diagnostics, doc.UnusedSuppressions()...)
+	}
+	return doc.Lines(), diagnostics
+}
+
+type source struct {
+	path  string
+	name  string
+	cell  int
+	lines []string
+}
+
+// diagnosticFilter post-processes the diagnostics of a source before they are
+// reported.
+type diagnosticFilter interface {
+	apply(src *source, diagnostics []parser.Diagnostic) ([]parser.Diagnostic, error)
+}
+
+// tester checks every file received on c and returns the number of problems
+// reported for each of them.
+func tester(c <-chan string, filters []diagnosticFilter) map[string]int {
+	results := make(map[string]int)
+	for f := range c {
+		results[f] = checkFile(f, filters)
+	}
+	return results
+}
+
+func checkFile(f string, filters []diagnosticFilter) int {
+	problems := 0
+	name := sourceName(f)
+	text, invalid, err := readSource(f)
+	if err != nil {
+		logrus.Error(err)
+		return 1
+	}
+	units, err := checkText(name, text)
+	if err != nil {
+		logrus.Errorf("File %s: %s", name, err)
+		return 1
+	}
+	if len(invalid) > 0 {
+		// The positions of invalid sequences are those of the whole file,
+		// which the cells of a notebook are not.
+		if units[0].cell != 0 {
+			units = append([]unit{{lines: parser.SplitLines(text)}}, units...)
+		}
+		units[0].diagnostics = append(invalid, units[0].diagnostics...)
+	}
+	for _, u := range units {
+		src := &source{path: f, name: name, cell: u.cell, lines: u.lines}
+		diagnostics := u.diagnostics
+		for _, filter := range filters {
 			if err != nil {
-				fh.Close()
-				return fmt.Errorf("File %s: %s", f, err)
+				break
 			}
+			diagnostics, err = filter.apply(src, diagnostics)
 		}
+		if err != nil {
+			logrus.Errorf("File %s: %s", name, err)
+			return 1
+		}
+		for _, d := range diagnostics {
+			if d.Severity == parser.SeverityError {
+				problems++
+			}
+		}
+		if err := reporter.Report(&report.File{Name: name, Cell: u.cell, Lines: u.lines, Diagnostics: diagnostics}); err != nil {
+			logrus.Errorf("Cannot report diagnostics: %s", err)
+		}
+	}
+	return problems
+}
+
+// staged holds the files checked with --staged, whose content is read from
+// the index rather than from the working tree.
+var staged struct {
+	repo    *git.Repo
+	changes map[string]git.Change
+}
+
+// diffBase returns the revision that changes are taken against: HEAD for
+// --staged, else the point where the branch forked from --changed-since.
+func diffBase(repo *git.Repo) (string, error) {
+	if config.Staged {
+		return "HEAD", nil
+	}
+	base, err := repo.MergeBase(config.ChangedSince)
+	if err != nil {
+		return "", fmt.Errorf("Cannot find merge base with %s: %s", config.ChangedSince, err)
+	}
+	return base, nil
+}
 
-		if err := scanner.Err(); err != nil {
-			fh.Close()
-			return err
+// changedFiles asks git for the files touched since base or staged in the
+// index, keyed by their path relative to the working directory. Files that
+// no longer exist in the working tree are skipped.
+func changedFiles(repo *git.Repo, base string, pathspecs []string) (map[string]git.Change, error) {
+	var changes []git.Change
+	var err error
+	if config.Staged {
+		changes, err = repo.Staged(pathspecs)
+	} else {
+		changes, err = repo.ChangedSince(base, pathspecs)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Cannot list changed files: %s", err)
+	}
+	cwd, err := os.Getwd()
+	if err == nil {
+		cwd, err = filepath.EvalSymlinks(cwd)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Cannot get working directory: %s", err)
+	}
+	files := make(map[string]git.Change, len(changes))
+	for _, c := range changes {
+		if c.Status == git.StatusDeleted {
+			continue
 		}
-		if !parser.Empty() {
-			b := parser.Top()
-			return fmt.Errorf("File %s: Unclosed %s bracket at line: %v, col: %v", f, string(b.Kind), b.Line, b.Col)
+		if _, err := os.Stat(c.Path); err != nil {
+			logrus.Debugf("Skipping %s: %s", c.Path, err)
+			continue
 		}
+		path := c.Path
+		if rel, err := filepath.Rel(cwd, path); err == nil {
+			path = rel
+		}
+		files[path] = c
 	}
-	return nil
+	return files, nil
+}
+
+func newReporter(format string, color bool) (report.Reporter, error) {
+	if format == "" {
+		format = "gnu"
+		if report.IsTerminal(os.Stdout) {
+			format = "pretty"
+		}
+	}
+	if format == "pretty" {
+		return report.NewPretty(os.Stdout, color), nil
+	}
+	cwd, err := os.Getwd()
+	if err != nil {
+		return nil, fmt.Errorf("Cannot get working directory: %s", err)
+	}
+	switch format {
+	case "junit":
+		return report.NewJUnit(os.Stdout, cwd), nil
+	case "checkstyle":
+		return report.NewCheckstyle(os.Stdout, cwd), nil
+	case "github":
+		return report.NewGitHub(os.Stdout, cwd), nil
+	case "gitlab":
+		return report.NewGitLab(os.Stdout, cwd), nil
+	}
+	return report.NewGNU(os.Stdout, cwd), nil
 }
 
 type Config struct {
-	Version bool `short:"v" long:"version" description:"Print version"`
-	Args    struct {
+	Version                  bool   `short:"v" long:"version" description:"Print version"`
+	Encoding                 string `long:"encoding" default:"utf-8" description:"Encoding of files without a BOM that are not valid UTF-8 (utf-8, latin1, windows-1252, utf-16le, utf-16be)"`
+	FollowSymlinks           bool   `short:"L" long:"follow-symlinks" description:"Descend into symbolic links to directories found while walking directories"`
+	OneFileSystem            bool   `short:"x" long:"one-file-system" description:"Do not descend into directories on other filesystems"`
+	StdinFilename            string `long:"stdin-filename" description:"Name used for the source read from stdin (-) to pick its language and label diagnostics"`
+	FilesFrom                string `long:"files-from" value-name:"FILE" description:"Read the paths to check from FILE, or from stdin if FILE is -"`
+	Null                     bool   `short:"0" long:"null" description:"Paths in --files-from are separated by NUL instead of newlines"`
+	ChangedSince             string `long:"changed-since" value-name:"REV" description:"Only check files changed in git since the branch forked from REV; paths restrict the files considered"`
+	Staged                   bool   `long:"staged" description:"Only check the staged content of files with changes staged in git; paths restrict the files considered"`
+	DiffOnly                 bool   `long:"diff-only" description:"With --changed-since or --staged, only report diagnostics on changed lines or missing from the old revision"`
+	Baseline                 string `long:"baseline" value-name:"FILE" description:"Only report diagnostics not recorded in the baseline FILE"`
+	WriteBaseline            string `long:"write-baseline" value-name:"FILE" description:"Record the current diagnostics in the baseline FILE instead of reporting them"`
+	ReportUnusedSuppressions bool   `long:"report-unused-suppressions" description:"Warn about drbracket: comment directives that suppress nothing"`
+	Format                   string `long:"format" choice:"pretty" choice:"gnu" choice:"junit" choice:"checkstyle" choice:"github" choice:"gitlab" description:"Output format of the diagnostics (default: pretty on a terminal, gnu otherwise)"`
+	Color                    string `long:"color" default:"auto" choice:"auto" choice:"always" choice:"never" description:"Colour the diagnostics; auto does when printing to a terminal and NO_COLOR is not set"`
+	Watch                    bool   `short:"w" long:"watch" description:"Keep running and check files again whenever they change"`
+	Args                     struct {
 		Paths []string
-	} `positional-args:"yes" required:"yes"`
+	} `positional-args:"yes"`
 }
 
+const stdinPath = "-"
+
 var config = Config{
 	Version: false,
 }
 
+var reporter report.Reporter
+
 var Version = "use `make build' to fill correctly {VERSION}"
 var Revision = "{REVISION}"
 
@@ -184,7 +523,12 @@ func fullVersion() string {
 
 func main() {
 	var parser = flags.NewParser(&config, flags.Default)
-	_, err := parser.Parse()
+	args := os.Args[1:]
+	lspMode := len(args) > 0 && args[0] == "lsp"
+	if lspMode {
+		args = args[1:]
+	}
+	_, err := parser.ParseArgs(args)
 	if err != nil {
 		if e, ok := err.(*flags.Error); ok {
 			if e.Type == flags.ErrHelp {
@@ -197,15 +541,132 @@ func main() {
 	if config.Version {
 		fmt.Printf("Version: %s\n", fullVersion())
 	}
+	if _, err := charset.Normalize(config.Encoding); err != nil {
+		logrus.Fatalf(err.Error())
+	}
+	if lspMode {
+		if err := lsp.NewServer(fullVersion(), config.ReportUnusedSuppressions).Serve(os.Stdin, os.Stdout); err != nil {
+			logrus.Fatalf(err.Error())
+		}
+		return
+	}
+
+	color, err := report.UseColor(config.Color, os.Stdout)
+	if err != nil {
+		logrus.Fatalf(err.Error())
+	}
+	reporter, err = newReporter(config.Format, color)
+	if err != nil {
+		logrus.Fatalf(err.Error())
+	}
+
+	paths := config.Args.Paths
+	gitMode := config.Staged || config.ChangedSince != ""
+	if config.Staged && config.ChangedSince != "" {
+		logrus.Fatalf("--staged and --changed-since cannot be used together")
+	}
+	if config.DiffOnly && !gitMode {
+		logrus.Fatalf("--diff-only requires --staged or --changed-since")
+	}
+	if config.Watch && (gitMode || config.WriteBaseline != "") {
+		logrus.Fatalf("--watch cannot be used with --staged, --changed-since or --write-baseline")
+	}
+	if config.Watch && (config.Format == "junit" || config.Format == "checkstyle" || config.Format == "gitlab") {
+		logrus.Fatalf("--watch cannot write %s reports", config.Format)
+	}
+	filters := make([]diagnosticFilter, 0)
+	if gitMode {
+		if config.FilesFrom != "" {
+			logrus.Fatalf("--files-from cannot be used with --staged or --changed-since")
+		}
+		repo, err := git.Open(".")
+		if err != nil {
+			logrus.Fatalf(err.Error())
+		}
+		base, err := diffBase(repo)
+		if err != nil {
+			logrus.Fatalf(err.Error())
+		}
+		changes, err := changedFiles(repo, base, paths)
+		if err != nil {
+			logrus.Fatalf(err.Error())
+		}
+		if config.Staged {
+			staged.repo, staged.changes = repo, changes
+		}
+		if len(changes) == 0 {
+			// Report formats still need their empty document.
+			if err := reporter.Finish(); err != nil {
+				logrus.Fatalf(err.Error())
+			}
+			return
+		}
+		paths = make([]string, 0, len(changes))
+		for path := range changes {
+			paths = append(paths, path)
+		}
+		sort.Strings(paths)
+		if config.DiffOnly {
+			filters = append(filters, newDiffFilter(repo, base, changes))
+		}
+	}
+	var baseFilter *baselineFilter
+	if config.Baseline != "" || config.WriteBaseline != "" {
+		if config.Baseline != "" && config.WriteBaseline != "" {
+			logrus.Fatalf("--baseline and --write-baseline cannot be used together")
+		}
+		baseFilter, err = newBaselineFilter(config.Baseline)
+		if err != nil {
+			logrus.Fatalf(err.Error())
+		}
+		filters = append(filters, baseFilter)
+	}
+	if config.FilesFrom != "" {
+		for _, p := range paths {
+			if p == stdinPath && config.FilesFrom == stdinPath {
+				logrus.Fatalf("Cannot read both sources and --files-from from stdin")
+			}
+		}
+		listed, err := readFileList(config.FilesFrom, config.Null)
+		if err != nil {
+			logrus.Fatalf(err.Error())
+		}
+		paths = append(paths, listed...)
+	}
+	if len(paths) == 0 {
+		if config.Version {
+			return
+		}
+		logrus.Fatalf("No paths given")
+	}
+
+	if config.Watch {
+		// Changes are reported with clean paths, which the results of the
+		// first run must be keyed by as well.
+		for i, p := range paths {
+			paths[i] = filepath.Clean(p)
+		}
+	}
 
 	fchan := make(chan string, 100)
 	wgAll := sync.WaitGroup{}
 	wgWalkers := sync.WaitGroup{}
-	for _, path := range config.Args.Paths {
+	visited := newVisitedSet()
+	stdinSeen := false
+	for _, path := range paths {
+		if path == stdinPath {
+			if config.Watch {
+				logrus.Fatalf("--watch cannot check stdin")
+			}
+			if stdinSeen {
+				continue
+			}
+			stdinSeen = true
+		}
 		wgAll.Add(1)
 		wgWalkers.Add(1)
 		go func(p string) {
-			err := walker(p, fchan)
+			err := walker(p, fchan, visited)
 			if err != nil {
 				logrus.Fatalf(err.Error())
 			}
@@ -214,16 +675,33 @@ func main() {
 		}(path)
 	}
 
+	var results map[string]int
 	wgAll.Add(1)
 	go func() {
-		err := tester(fchan)
-		if err != nil {
-			logrus.Error(err)
-		}
+		results = tester(fchan, filters)
 		wgAll.Done()
 	}()
 
 	wgWalkers.Wait()
 	close(fchan)
 	wgAll.Wait()
+	if config.Watch {
+		if err := watchPaths(paths, results, filters, baseFilter); err != nil {
+			logrus.Fatalf(err.Error())
+		}
+		return
+	}
+	if err := reporter.Finish(); err != nil {
+		logrus.Fatalf(err.Error())
+	}
+	if baseFilter != nil {
+		if err := baseFilter.finish(config.WriteBaseline); err != nil {
+			logrus.Fatalf(err.Error())
+		}
+	}
+	for _, n := range results {
+		if n > 0 {
+			os.Exit(1)
+		}
+	}
 }
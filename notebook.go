@@ -0,0 +1,57 @@
+// Copyright (C) 2020 Fabio Del Vigna
+//
+// This file is part of drbracket.
+//
+// drbracket is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// drbracket is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with drbracket.  If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"path/filepath"
+	"strings"
+
+	"github.com/yoskini/drbracket/lib/notebook"
+	"github.com/yoskini/drbracket/lib/parser"
+)
+
+func isNotebook(name string) bool {
+	return strings.EqualFold(filepath.Ext(name), ".ipynb")
+}
+
+// checkNotebook checks the code cells of a notebook with the rules of the
+// kernel language, or of the language a cell magic switches to.
+func checkNotebook(text string) ([]unit, error) {
+	kernel, cells, err := notebook.Read([]byte(text))
+	if err != nil {
+		return nil, err
+	}
+	lang := parser.LanguageForName(kernel)
+	if lang == nil {
+		lang = parser.LanguageDefault
+	}
+	units := make([]unit, 0, len(cells))
+	for _, c := range cells {
+		cellLang := lang
+		if l := parser.LanguageForName(c.Language); l != nil {
+			cellLang = l
+		}
+		lines, diagnostics := checkDocument(cellLang, c.Source)
+		units = append(units, unit{cell: c.Index, lines: lines, diagnostics: diagnostics})
+	}
+	if len(units) == 0 {
+		// Reports that list every checked file still list the notebook.
+		units = append(units, unit{})
+	}
+	return units, nil
+}
@@ -0,0 +1,6 @@
+#!/bin/sh
+n=${#1}
+case "$1" in
+  a#b) echo "hash (";;
+  *) echo $n ;; # a comment )
+esac
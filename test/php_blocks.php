@@ -0,0 +1,10 @@
+<ul>
+<?php foreach ($items as $item) { ?>
+  <li><?= $item ?></li>
+<?php } ?>
+</ul>
+<?php if ($a) { ?>
+  <div class="a">a</div>
+<?php } else { ?>
+  <div class="b">b</div>
+<?php } ?>
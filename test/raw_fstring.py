@@ -0,0 +1,4 @@
+_dash = "-"
+pattern = rf'(\{{[{_dash}])'
+digits = Rf"[\d{{2}}{_dash}]"
+braces = f'\{{ {len(pattern)} }}'
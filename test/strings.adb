@@ -0,0 +1,6 @@
+procedure Main is
+   A : Character := '(';
+   B : String := "a "")";
+begin
+   Put_Line (B'Image);
+end Main;
@@ -0,0 +1,7 @@
+main :: IO ()
+main = do
+  let open = '('
+      close = ')'
+      s = "[" ++ [open, close]
+  print (s, map' id [1])
+  where map' = map
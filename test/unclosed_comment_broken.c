@@ -0,0 +1,7 @@
+#include <stdio.h>
+int main() {
+   printf("Hello, World!");
+   return 0;
+}
+/* printf() displays the string inside quotation
+int f() {
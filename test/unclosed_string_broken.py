@@ -0,0 +1,5 @@
+def main():
+    print("Hello, World!")
+
+usage = """
+main(
@@ -0,0 +1,112 @@
+// Copyright (C) 2020 Fabio Del Vigna
+//
+// This file is part of drbracket.
+//
+// drbracket is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// drbracket is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with drbracket.  If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/yoskini/drbracket/lib/watch"
+)
+
+// watchDelay is how long the files must stay untouched before a burst of
+// writes is checked.
+const watchDelay = 200 * time.Millisecond
+
+// watchPaths keeps checking the files under paths, which must be clean, as
+// they change, starting from the results of the first run.
+func watchPaths(paths []string, results map[string]int, filters []diagnosticFilter, baseFilter *baselineFilter) error {
+	w, err := watch.New(watchDelay)
+	if err != nil {
+		return err
+	}
+	defer w.Close()
+	dirs := make([]string, 0)
+	files := make(map[string]bool)
+	for _, p := range paths {
+		stat, err := os.Stat(p)
+		if err != nil {
+			return err
+		}
+		if stat.IsDir() {
+			dirs = append(dirs, p)
+			err = w.Add(p, true)
+		} else {
+			files[p] = true
+			err = w.Add(filepath.Dir(p), false)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	watched := func(path string) bool {
+		if files[path] {
+			return true
+		}
+		if !HasCodeExtension(path) {
+			return false
+		}
+		for _, dir := range dirs {
+			if rel, err := filepath.Rel(dir, path); err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+				return true
+			}
+		}
+		return false
+	}
+
+	logStatus(results)
+	for {
+		select {
+		case batch := <-w.Changes:
+			checked := 0
+			for _, path := range batch {
+				if !watched(path) {
+					continue
+				}
+				checked++
+				if stat, err := os.Stat(path); err != nil || !stat.Mode().IsRegular() {
+					delete(results, path)
+					continue
+				}
+				if baseFilter != nil {
+					baseFilter.rewind(path)
+				}
+				results[path] = checkFile(path, filters)
+			}
+			if checked > 0 {
+				logStatus(results)
+			}
+		case err := <-w.Errors:
+			logrus.Warn(err)
+		}
+	}
+}
+
+func logStatus(results map[string]int) {
+	problems, failing := 0, 0
+	for _, n := range results {
+		problems += n
+		if n > 0 {
+			failing++
+		}
+	}
+	logrus.Infof("%s Watching %d files: %d problems in %d files", time.Now().Format("15:04:05"), len(results), problems, failing)
+}